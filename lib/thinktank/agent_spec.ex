@@ -3,6 +3,8 @@ defmodule Thinktank.AgentSpec do
   Typed Pi agent configuration.
   """
 
+  @retry_categories ~w(crash timeout empty_output)
+
   @enforce_keys [:name, :provider, :model, :system_prompt, :thinking_level]
   defstruct [
     :name,
@@ -12,8 +14,14 @@ defmodule Thinktank.AgentSpec do
     :thinking_level,
     task_prompt: "{{input_text}}",
     retries: 0,
+    retry_on: [:crash, :empty_output],
+    retry_delay_ms: 250,
+    retry_multiplier: 2,
+    retry_max_delay_ms: 10_000,
     timeout_ms: :timer.minutes(5),
+    fallback_model: nil,
     tools: nil,
+    vision: false,
     metadata: %{}
   ]
 
@@ -25,8 +33,14 @@ defmodule Thinktank.AgentSpec do
           task_prompt: String.t(),
           thinking_level: String.t(),
           retries: non_neg_integer(),
+          retry_on: [atom()],
+          retry_delay_ms: non_neg_integer(),
+          retry_multiplier: number(),
+          retry_max_delay_ms: non_neg_integer(),
           timeout_ms: non_neg_integer(),
+          fallback_model: String.t() | nil,
           tools: [String.t()] | nil,
+          vision: boolean(),
           metadata: map()
         }
 
@@ -43,16 +57,29 @@ defmodule Thinktank.AgentSpec do
     with {:ok, provider} <- require_string(raw, "provider"),
          {:ok, model} <- require_string(raw, "model"),
          :ok <- validate_model(model),
+         {:ok, fallback_model} <- parse_fallback_model(raw["fallback_model"]),
          {:ok, system_prompt} <- require_string(raw, "system_prompt"),
          {:ok, thinking_level} <-
            require_present_string(thinking_level, "agent thinking_level is required"),
          {:ok, retries} <- parse_non_neg_int("retries", raw["retries"], 0),
+         {:ok, retry_on} <- parse_retry_on(setting(raw, defaults, "retry_on")),
+         {:ok, retry_delay_ms} <-
+           parse_non_neg_int("retry_delay_ms", setting(raw, defaults, "retry_delay_ms"), 250),
+         {:ok, retry_multiplier} <-
+           parse_multiplier(setting(raw, defaults, "retry_multiplier"), 2),
+         {:ok, retry_max_delay_ms} <-
+           parse_non_neg_int(
+             "retry_max_delay_ms",
+             setting(raw, defaults, "retry_max_delay_ms"),
+             10_000
+           ),
          {:ok, timeout_ms} <-
            parse_non_neg_int(
              "timeout_ms",
              raw["timeout_ms"] || raw["timeout"],
              :timer.minutes(5)
-           ) do
+           ),
+         {:ok, vision} <- parse_boolean("vision", raw["vision"], false) do
       {:ok,
        %__MODULE__{
          name: name,
@@ -62,8 +89,14 @@ defmodule Thinktank.AgentSpec do
          task_prompt: string_or_default(raw["task_prompt"] || raw["prompt"], "{{input_text}}"),
          thinking_level: thinking_level,
          retries: retries,
+         retry_on: retry_on,
+         retry_delay_ms: retry_delay_ms,
+         retry_multiplier: retry_multiplier,
+         retry_max_delay_ms: retry_max_delay_ms,
          timeout_ms: timeout_ms,
+         fallback_model: fallback_model,
          tools: parse_tools(raw["tools"]),
+         vision: vision,
          metadata: Map.get(raw, "metadata", %{})
        }}
     end
@@ -101,6 +134,18 @@ defmodule Thinktank.AgentSpec do
     end
   end
 
+  defp parse_fallback_model(nil), do: {:ok, nil}
+
+  defp parse_fallback_model(value) when is_binary(value) do
+    cond do
+      String.trim(value) == "" -> {:ok, nil}
+      validate_model(value) == :ok -> {:ok, value}
+      true -> {:error, "agent fallback_model must not contain whitespace"}
+    end
+  end
+
+  defp parse_fallback_model(_value), do: {:error, "agent fallback_model must be a string"}
+
   defp parse_non_neg_int(_field, nil, default), do: {:ok, default}
 
   defp parse_non_neg_int(_field, value, _default) when is_integer(value) and value >= 0,
@@ -116,6 +161,42 @@ defmodule Thinktank.AgentSpec do
   defp parse_non_neg_int(field, _value, _default),
     do: {:error, "agent #{field} must be a non-negative integer"}
 
+  # Timeouts are opt-in: each timed-out attempt already spent the full
+  # `timeout_ms`, and the agent's task budget grows with every attempt.
+  defp parse_retry_on(nil), do: {:ok, [:crash, :empty_output]}
+
+  defp parse_retry_on(value) when is_binary(value),
+    do: value |> String.split(",") |> Enum.map(&String.trim/1) |> parse_retry_on()
+
+  defp parse_retry_on(categories) when is_list(categories) do
+    if Enum.all?(categories, &(&1 in @retry_categories)),
+      do: {:ok, categories |> Enum.uniq() |> Enum.map(&String.to_existing_atom/1)},
+      else: parse_retry_on(:invalid)
+  end
+
+  defp parse_retry_on(_value),
+    do: {:error, "agent retry_on must list only #{Enum.join(@retry_categories, ", ")}"}
+
+  defp parse_boolean(_field, nil, default), do: {:ok, default}
+  defp parse_boolean(_field, value, _default) when is_boolean(value), do: {:ok, value}
+  defp parse_boolean(field, _value, _default),
+    do: {:error, "agent #{field} must be true or false"}
+
+  defp setting(raw, defaults, key), do: Map.get(raw, key, Map.get(defaults, key))
+
+  defp parse_multiplier(nil, default), do: {:ok, default}
+  defp parse_multiplier(value, _default) when is_number(value) and value >= 1, do: {:ok, value}
+
+  defp parse_multiplier(value, _default) when is_binary(value) do
+    case Float.parse(value) do
+      {parsed, ""} when parsed >= 1 -> {:ok, parsed}
+      _ -> {:error, "agent retry_multiplier must be a number >= 1"}
+    end
+  end
+
+  defp parse_multiplier(_value, _default),
+    do: {:error, "agent retry_multiplier must be a number >= 1"}
+
   defp parse_tools(nil), do: nil
   defp parse_tools(tools) when is_list(tools), do: Enum.filter(tools, &is_binary/1)
 
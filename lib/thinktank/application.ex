@@ -4,10 +4,13 @@ defmodule Thinktank.Application do
   use Application
   require Logger
 
-  alias Thinktank.{RuntimeTables, RunTracker, SignalHandler}
+  alias Thinktank.{Redaction, RuntimeTables, RunTracker, SignalHandler}
 
   @impl true
   def start(_type, _args) do
+    Redaction.refresh_secrets()
+    :logger.add_primary_filter(:thinktank_redaction, {&Redaction.logger_filter/2, nil})
+
     children = [
       RuntimeTables,
       {Task.Supervisor, name: Thinktank.AgentSupervisor}
@@ -18,6 +18,7 @@ defmodule Thinktank.ArtifactLayout do
   @review_coverage_file "review/coverage.json"
   @review_degrade_policy_file "review/degrade_policy.json"
   @agents_dir "agents"
+  @failures_dir "failures"
   @artifacts_dir "artifacts"
   @prompts_dir "prompts"
   @pi_home_dir "pi-home"
@@ -54,8 +55,11 @@ defmodule Thinktank.ArtifactLayout do
 
   @dynamic_artifact_files [
     Path.join(@agents_dir, "{instance_id}.md"),
+    Path.join(@agents_dir, "{instance_id}.raw.md"),
     Path.join(@scratchpads_dir, "{instance_id}.md"),
-    Path.join(@streams_dir, "{instance_id}.txt")
+    Path.join(@prompts_dir, "{instance_id}.md"),
+    Path.join(@streams_dir, "{instance_id}.txt"),
+    Path.join(@failures_dir, "{instance_id}.json")
   ]
 
   @required_path_contract_entries @artifact_files ++
@@ -150,12 +154,21 @@ defmodule Thinktank.ArtifactLayout do
   @spec agent_result_file(String.t()) :: String.t()
   def agent_result_file(instance_id), do: Path.join(@agents_dir, "#{instance_id}.md")
 
+  @spec agent_raw_result_file(String.t()) :: String.t()
+  def agent_raw_result_file(instance_id), do: Path.join(@agents_dir, "#{instance_id}.raw.md")
+
+  @spec agent_failure_file(String.t()) :: String.t()
+  def agent_failure_file(instance_id), do: Path.join(@failures_dir, "#{instance_id}.json")
+
   @spec run_scratchpad_file() :: String.t()
   def run_scratchpad_file, do: @run_scratchpad_file
 
   @spec agent_scratchpad_file(String.t()) :: String.t()
   def agent_scratchpad_file(instance_id), do: Path.join(@scratchpads_dir, "#{instance_id}.md")
 
+  @spec agent_prompt_file(String.t()) :: String.t()
+  def agent_prompt_file(instance_id), do: Path.join(@prompts_dir, "#{instance_id}.md")
+
   @spec agent_stream_file(String.t()) :: String.t()
   def agent_stream_file(instance_id), do: Path.join(@streams_dir, "#{instance_id}.txt")
 
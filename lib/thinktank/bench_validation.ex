@@ -36,6 +36,7 @@ defmodule Thinktank.BenchValidation do
         {:warning, warning} -> [warning]
         _ -> []
       end)
+      |> Kernel.++(unprobed_credential_warnings(benches, config, probe_results, opts))
       |> Enum.uniq_by(&warning_key/1)
 
     errors =
@@ -81,6 +82,48 @@ defmodule Thinktank.BenchValidation do
     |> Enum.uniq()
   end
 
+  # Only a probe that actually ran has checked its provider's credential. Agents
+  # without declared tools (typically planners and synthesizers) are never
+  # probed, and adapters without a catalog probe skip it, so those providers
+  # would otherwise go unchecked until a run reaches them.
+  defp unprobed_credential_warnings(benches, config, probe_results, opts) do
+    probed =
+      for {{provider_id, _model}, result} <- probe_results,
+          not match?({:warning, %{code: "provider_capability_probe_unsupported"}}, result),
+          into: MapSet.new(),
+          do: provider_id
+
+    benches
+    |> Enum.flat_map(&bench_agent_names/1)
+    |> Enum.flat_map(fn name ->
+      case Map.fetch(config.agents, name) do
+        {:ok, %AgentSpec{provider: provider_id}} -> [provider_id]
+        :error -> []
+      end
+    end)
+    |> Enum.uniq()
+    |> Enum.reject(&MapSet.member?(probed, &1))
+    |> Enum.flat_map(&credential_warning(Map.fetch!(config.providers, &1), opts))
+  end
+
+  defp credential_warning(%ProviderSpec{credential_env: nil}, _opts), do: []
+
+  defp credential_warning(provider, opts) do
+    case provider_api_key(provider, opts) do
+      {:ok, _key} ->
+        []
+
+      {:warning, warning} ->
+        [
+          %{
+            warning
+            | message:
+                "provider #{provider.id} has no credential because #{missing_envs_phrase(provider)}; agents using it will fail at launch"
+          }
+        ]
+    end
+  end
+
   @spec probe_results([capability_entry()], %{String.t() => ProviderSpec.t()}, keyword()) ::
           %{probe_key() => {:ok, [String.t()]} | {:warning, map()}}
   defp probe_results(entries, providers, opts) do
@@ -177,31 +220,39 @@ defmodule Thinktank.BenchValidation do
           do: non_empty_env(env_reader, fallback_env)
         )
 
-    if is_binary(key) and key != "" do
-      {:ok, key}
-    else
-      {:warning, missing_credentials_warning(provider)}
+    keys =
+      key
+      |> to_string()
+      |> String.split(",")
+      |> Enum.map(&String.trim/1)
+      |> Enum.reject(&(&1 == ""))
+
+    case keys do
+      [first_key | _] -> {:ok, first_key}
+      [] -> {:warning, missing_credentials_warning(provider)}
     end
   end
 
   defp missing_credentials_warning(provider) do
-    fallback_env = provider.defaults["fallback_env"]
-
-    envs =
-      [provider.credential_env, fallback_env]
-      |> Enum.filter(&is_binary/1)
-      |> Enum.reject(&(&1 == ""))
-
     %{
       code: "provider_credentials_missing",
       provider: provider.id,
       credential_env: provider.credential_env,
-      fallback_env: fallback_env,
+      fallback_env: provider.defaults["fallback_env"],
       message:
-        "skipped capability validation for provider #{provider.id} because #{Enum.join(envs, " and ")} #{if(length(envs) == 1, do: "is", else: "are")} unset; benches were structurally validated only"
+        "skipped capability validation for provider #{provider.id} because #{missing_envs_phrase(provider)}; benches were structurally validated only"
     }
   end
 
+  defp missing_envs_phrase(provider) do
+    envs =
+      [provider.credential_env, provider.defaults["fallback_env"]]
+      |> Enum.filter(&is_binary/1)
+      |> Enum.reject(&(&1 == ""))
+
+    "#{Enum.join(envs, " and ")} #{if(length(envs) == 1, do: "is", else: "are")} unset"
+  end
+
   defp ensure_http_support do
     with {:ok, _} <- Application.ensure_all_started(:ssl),
          {:ok, _} <- Application.ensure_all_started(:inets) do
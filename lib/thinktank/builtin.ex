@@ -7,6 +7,13 @@ defmodule Thinktank.Builtin do
   @external_resource @builtin_config_path
   @builtin_config_yaml File.read!(@builtin_config_path)
 
+  @spec config_hash() :: String.t()
+  def config_hash do
+    :crypto.hash(:sha256, @builtin_config_yaml)
+    |> Base.encode16(case: :lower)
+    |> binary_part(0, 12)
+  end
+
   @spec raw_config() :: map()
   def raw_config do
     case YamlElixir.read_from_string(@builtin_config_yaml) do
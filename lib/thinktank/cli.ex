@@ -4,18 +4,31 @@ defmodule Thinktank.CLI do
   """
 
   alias Thinktank.BenchValidation
-  alias Thinktank.CLI.Parser
-  alias Thinktank.CLI.Render
+  alias Thinktank.CLI.{
+    ExitClass,
+    InputError,
+    Parser,
+    Render,
+    ResolvedConfig,
+    RunSummary,
+    StreamLines
+  }
   alias Thinktank.Config
   alias Thinktank.Engine
+  alias Thinktank.EnvFile
   alias Thinktank.Error
   alias Thinktank.ProgressReporter
   alias Thinktank.Review.Eval
   alias Thinktank.RunInspector
+  alias Thinktank.Version
 
   @exit_codes %{
     success: 0,
     generic_error: 1,
+    degraded: 3,
+    run_failed: 4,
+    synthesis_failed: 5,
+    timed_out: 6,
     input_error: 7
   }
 
@@ -31,35 +44,48 @@ defmodule Thinktank.CLI do
         {:needs_stdin, parsed} -> read_stdin(parsed)
         other -> other
       end)
+      |> InputError.with_format(args)
       |> execute()
 
     System.halt(exit_code)
   end
 
-  @spec execute({:ok, map()} | {:error, String.t()} | {:help, map()} | {:version, map()}) ::
-          non_neg_integer()
+  @spec execute(
+          {:ok | :help | :version, map()}
+          | {:error, atom(), String.t()}
+          | {:error, atom(), String.t(), map()}
+        ) :: non_neg_integer()
+  def execute({:ok, %{env_file: path} = command}) when is_binary(path) do
+    case EnvFile.load(path) do
+      {:ok, _loaded} -> execute({:ok, Map.delete(command, :env_file)})
+      {:error, reason} -> execute({:error, :unreadable_env_file, reason, command})
+    end
+  end
+
   def execute({:help, _}) do
     IO.puts(Render.usage_text(version()))
     @exit_codes.success
   end
 
-  def execute({:version, _}) do
-    IO.puts("thinktank #{version()}")
+  def execute({:version, command}) do
+    [trust_repo_config: Map.get(command, :trust_repo_config)]
+    |> Version.info()
+    |> emit_rendered(command, &Jason.encode!/1, &Version.format/1)
     @exit_codes.success
   end
 
-  def execute({:error, message}) do
-    IO.puts(:stderr, "Error: #{message}")
+  def execute({:error, code, message}), do: execute({:error, code, message, %{}})
+
+  def execute({:error, code, message, command}) do
+    emit_error(command, InputError.new(code, message), nil)
     @exit_codes.input_error
   end
 
-  def execute({:ok, %{action: :benches_list} = command}) do
+  def execute({:ok, %{action: action} = command}) when action in [:benches_list, :agents_list] do
     case load_config(command) do
       {:ok, config} ->
-        config
-        |> Config.list_benches()
-        |> emit_benches_list(command)
-
+        {payload, json_fun, text_fun} = Render.listing(action, config)
+        emit_rendered(payload, command, json_fun, text_fun)
         @exit_codes.success
 
       {:error, reason} ->
@@ -72,19 +98,8 @@ defmodule Thinktank.CLI do
     with {:ok, config} <- load_config(command),
          {:ok, bench} <- Config.bench(config, bench_id),
          {:ok, agents_payload} <- Render.resolve_agents_payload(bench, config, command.full) do
-      payload = %{
-        id: bench.id,
-        description: bench.description,
-        kind: bench.kind,
-        structured_findings: bench.structured_findings,
-        agents: agents_payload,
-        planner: bench.planner,
-        synthesizer: bench.synthesizer,
-        concurrency: bench.concurrency,
-        default_task: bench.default_task
-      }
-
-      emit_benches_show(payload, command)
+      payload = Render.benches_show_payload(bench, agents_payload)
+      emit_rendered(payload, command, &Render.benches_show_json/1, &Render.benches_show_text/1)
       @exit_codes.success
     else
       {:error, reason} ->
@@ -96,8 +111,13 @@ defmodule Thinktank.CLI do
   def execute({:ok, %{action: :benches_validate} = command}) do
     case load_config(command) do
       {:ok, config} ->
-        report = BenchValidation.validate(config, benches_validate_opts(command))
-        emit_benches_validate(report, command)
+        report = BenchValidation.validate(config, Parser.benches_validate_opts(command))
+        emit_rendered(
+          report,
+          command,
+          &Render.benches_validate_json/1,
+          &Render.benches_validate_text/1
+        )
 
         case report do
           %{status: "ok"} -> @exit_codes.success
@@ -111,9 +131,9 @@ defmodule Thinktank.CLI do
   end
 
   def execute({:ok, %{action: :runs_list} = command}) do
-    case command |> runs_list_opts() |> RunInspector.list() do
+    case command |> Parser.runs_list_opts() |> RunInspector.list() do
       {:ok, runs} ->
-        emit_runs_list(runs, command)
+        emit_rendered(runs, command, &Render.runs_list_json/1, &Render.runs_list_text/1)
         @exit_codes.success
 
       {:error, reason} ->
@@ -124,7 +144,7 @@ defmodule Thinktank.CLI do
   def execute({:ok, %{action: :runs_show, target: target} = command}) do
     case RunInspector.show(target) do
       {:ok, run} ->
-        emit_run(run, command)
+        emit_rendered(run, command, &Render.run_json/1, &Render.run_text/1)
         @exit_codes.success
 
       {:error, reason} ->
@@ -133,9 +153,9 @@ defmodule Thinktank.CLI do
   end
 
   def execute({:ok, %{action: :runs_wait, target: target} = command}) do
-    case RunInspector.wait(target, runs_wait_opts(command)) do
+    case RunInspector.wait(target, Parser.runs_wait_opts(command)) do
       {:ok, run} ->
-        emit_run(run, command)
+        emit_rendered(run, command, &Render.run_json/1, &Render.run_text/1)
 
         case run.status do
           "complete" -> @exit_codes.success
@@ -147,8 +167,26 @@ defmodule Thinktank.CLI do
     end
   end
 
+  def execute({:ok, %{action: :run, log_level: level} = command}) when not is_nil(level) do
+    previous_level = Logger.level()
+    Logger.configure(level: level)
+
+    try do
+      execute({:ok, %{command | log_level: nil}})
+    after
+      Logger.configure(level: previous_level)
+    end
+  end
+
   def execute({:ok, %{action: :run} = command}) do
-    if command.dry_run, do: dry_run(command), else: run_bench(command)
+    emit_warnings(command, Map.get(command, :warnings, []))
+
+    cond do
+      Map.get(command, :print_prompt) -> print_prompt(command)
+      Map.get(command, :print_config) -> dry_run(command, &ResolvedConfig.render(command, &1))
+      command.dry_run -> dry_run(command, &Render.dry_run_output(command, &1))
+      true -> run_bench(command)
+    end
   end
 
   def execute({:ok, %{action: :review_eval} = command}) do
@@ -179,64 +217,62 @@ defmodule Thinktank.CLI do
   def dry_run_output(command, resolved), do: Render.dry_run_output(command, resolved)
 
   @doc false
-  @spec read_stdin(map(), keyword()) :: {:ok, map()} | {:error, String.t()}
+  @spec read_stdin(map(), keyword()) :: {:ok, map()} | {:error, atom(), String.t()}
   def read_stdin(command, opts \\ []), do: Parser.read_stdin(command, opts)
 
   @doc false
   def render_run_payload(payload), do: Render.render_run_payload(payload)
 
   defp run_bench(command) do
-    agent_config_dir = agent_config_dir(command.cwd)
-
-    base_opts =
-      [cwd: command.cwd, output: command.output]
-      |> maybe_put_opt(:trust_repo_config, command.trust_repo_config)
-      |> maybe_put_opt(:config, Map.get(command, :config))
-
+    base_opts = resolve_opts(command)
+    agent_config_dir = Config.repo_agent_config_dir(command.cwd)
     run_opts = Keyword.put(base_opts, :agent_config_dir, agent_config_dir)
 
     case Engine.resolve(command.bench_id, command.input, base_opts) do
       {:ok, resolved} ->
         progress = maybe_start_progress(command, resolved)
+        stream = if is_nil(progress) and Map.get(command, :stream, false), do: StreamLines.start()
 
         result =
           try do
             run_opts
-            |> maybe_put_opt(:progress_callback, progress && ProgressReporter.callback(progress))
+            |> maybe_put_opt(:progress_callback, progress_callback(command, progress, stream))
             |> then(&Engine.run_resolved(resolved, &1))
           after
             ProgressReporter.stop(progress)
+            Enum.each(StreamLines.stop(stream), &IO.puts(:stderr, &1))
           end
 
         case result do
           {:ok, run_result} ->
             emit(command, Render.contract_payload(run_result.envelope))
-
-            case run_result.envelope.status do
-              "complete" -> @exit_codes.success
-              _ -> @exit_codes.generic_error
-            end
+            finish_run(command, run_result.output_dir, ExitClass.for_run(run_result))
 
           {:error, reason, output_dir} ->
-            emit_error(command, normalize_error(reason), output_dir)
-            @exit_codes.generic_error
+            error = normalize_error(reason)
+            emit_error(command, error, output_dir)
+            finish_run(command, output_dir, ExitClass.for_error(error))
         end
 
       {:error, reason, output_dir} ->
         emit_error(command, normalize_error(reason), output_dir)
-        @exit_codes.generic_error
+        finish_run(command, output_dir, :generic_error)
     end
   end
 
-  defp dry_run(command) do
-    resolve_opts =
-      [cwd: command.cwd, output: command.output]
-      |> maybe_put_opt(:trust_repo_config, command.trust_repo_config)
-      |> maybe_put_opt(:config, Map.get(command, :config))
+  defp finish_run(command, output_dir, exit_class) do
+    exit_code = Map.fetch!(@exit_codes, exit_class)
+    with path when is_binary(path) <- Map.get(command, :summary_file),
+         {:error, reason} <- RunSummary.write(path, output_dir, exit_class, exit_code),
+         do: emit_warnings(command, [reason])
+
+    exit_code
+  end
 
-    case Engine.resolve(command.bench_id, command.input, resolve_opts) do
+  defp dry_run(command, render) do
+    case Engine.resolve(command.bench_id, command.input, resolve_opts(command)) do
       {:ok, resolved} ->
-        emit(command, Render.dry_run_output(command, resolved))
+        emit(command, render.(resolved))
         @exit_codes.success
 
       {:error, reason, output_dir} ->
@@ -245,77 +281,72 @@ defmodule Thinktank.CLI do
     end
   end
 
-  defp maybe_start_progress(%{json: true}, resolved) do
-    ProgressReporter.start(
-      bench: resolved.bench.id,
-      output_dir: resolved.output_dir,
-      emit: &emit_progress_event/1
-    )
-  end
+  defp print_prompt(command) do
+    opts = resolve_opts(command)
 
-  defp maybe_start_progress(_command, _resolved), do: nil
+    with {:ok, resolved} <- Engine.resolve(command.bench_id, command.input, opts),
+         {:ok, prompts} <- Engine.preview_prompts(resolved) do
+      emit_rendered(prompts, command, &Render.prompts_json/1, &Render.prompts_text/1)
+      @exit_codes.success
+    else
+      {:error, reason, output_dir} ->
+        emit_error(command, normalize_error(reason), output_dir)
+        @exit_codes.input_error
 
-  defp emit_progress_event(payload) do
-    IO.puts(:stderr, Jason.encode!(payload))
+      {:error, reason} ->
+        emit_error(command, normalize_error(reason), nil)
+        @exit_codes.input_error
+    end
   end
 
-  defp emit_benches_list(benches, %{json: true}) do
-    benches
-    |> Render.benches_list_json()
-    |> IO.puts()
+  defp resolve_opts(command) do
+    [cwd: command.cwd, output: command.output]
+    |> maybe_put_opt(:trust_repo_config, command.trust_repo_config)
+    |> maybe_put_opt(:config, Map.get(command, :config))
+    |> maybe_put_opt(:require_credentials, Map.get(command, :validate))
+    |> maybe_put_opt(:allow_empty_output, Map.get(command, :allow_empty_response))
+    |> maybe_put_opt(:capture_requests, Map.get(command, :capture_requests))
   end
 
-  defp emit_benches_list(benches, _command) do
-    benches
-    |> Render.benches_list_text()
-    |> IO.puts()
+  defp maybe_start_progress(%{json: true} = command, resolved) do
+    ProgressReporter.start(
+      bench: resolved.bench.id,
+      output_dir: resolved.output_dir,
+      stream: Map.get(command, :stream, false),
+      emit: &emit_progress_event/1
+    )
   end
 
-  defp emit_benches_validate(benches, %{json: true}) do
-    benches
-    |> Render.benches_validate_json()
-    |> IO.puts()
-  end
+  defp maybe_start_progress(_command, _resolved), do: nil
 
-  defp emit_benches_validate(benches, _command) do
-    benches
-    |> Render.benches_validate_text()
-    |> IO.puts()
-  end
+  defp progress_callback(_command, progress, _stream) when is_pid(progress),
+    do: ProgressReporter.callback(progress)
 
-  defp benches_validate_opts(command) do
-    []
-    |> maybe_put_opt(:capability_probe, Map.get(command, :capability_probe))
-    |> maybe_put_opt(:env_reader, Map.get(command, :capability_env_reader))
-    |> maybe_put_opt(:http_requester, Map.get(command, :capability_http_requester))
-    |> maybe_put_opt(:max_concurrency, Map.get(command, :capability_max_concurrency))
-    |> maybe_put_opt(:probe_timeout_ms, Map.get(command, :capability_probe_timeout_ms))
-  end
+  defp progress_callback(command, nil, stream) do
+    progress? = Map.get(command, :text_progress, false)
+    started_mono = System.monotonic_time(:millisecond)
 
-  defp emit_benches_show(payload, %{json: true}) do
-    payload
-    |> Render.benches_show_json()
-    |> IO.puts()
+    if stream || progress? do
+      fn event, attrs ->
+        if stream, do: Enum.each(StreamLines.lines(stream, event, attrs), &IO.puts(:stderr, &1))
+        if progress?, do: emit_progress_line(event, attrs, started_mono)
+      end
+    end
   end
 
-  defp emit_benches_show(payload, _command) do
-    payload
-    |> Render.benches_show_text()
-    |> IO.puts()
-  end
+  defp emit_progress_line(event, attrs, started_mono) do
+    elapsed_ms = System.monotonic_time(:millisecond) - started_mono
 
-  defp runs_wait_opts(command) do
-    case Map.get(command, :timeout_ms) do
-      nil -> []
-      timeout_ms -> [timeout_ms: timeout_ms]
+    case Render.progress_line(event, attrs, elapsed_ms) do
+      nil -> :ok
+      line -> IO.puts(:stderr, line)
     end
   end
 
-  defp runs_list_opts(command) do
-    command
-    |> Map.take([:limit])
-    |> Enum.reject(fn {_key, value} -> is_nil(value) end)
-  end
+  defp emit_warnings(command, warnings),
+    do: Enum.each(warnings, &IO.puts(:stderr, Render.warning_line(command, &1)))
+
+  defp emit_progress_event(payload), do: IO.puts(:stderr, Jason.encode!(payload))
 
   defp emit_runs_error(command, reason) do
     error = normalize_error(reason)
@@ -323,9 +354,7 @@ defmodule Thinktank.CLI do
     runs_error_exit_code(error)
   end
 
-  defp runs_error_output_dir(%Error{details: %{output_dir: output_dir}})
-       when is_binary(output_dir),
-       do: output_dir
+  defp runs_error_output_dir(%Error{details: %{output_dir: dir}}) when is_binary(dir), do: dir
 
   defp runs_error_output_dir(_error), do: nil
 
@@ -335,30 +364,6 @@ defmodule Thinktank.CLI do
       else: @exit_codes.generic_error
   end
 
-  defp emit_runs_list(runs, %{json: true}) do
-    runs
-    |> Render.runs_list_json()
-    |> IO.puts()
-  end
-
-  defp emit_runs_list(runs, _command) do
-    runs
-    |> Render.runs_list_text()
-    |> IO.puts()
-  end
-
-  defp emit_run(run, %{json: true}) do
-    run
-    |> Render.run_json()
-    |> IO.puts()
-  end
-
-  defp emit_run(run, _command) do
-    run
-    |> Render.run_text()
-    |> IO.puts()
-  end
-
   defp normalize_error(%Error{} = error), do: error
   defp normalize_error(reason), do: Error.from_reason(reason)
 
@@ -377,6 +382,11 @@ defmodule Thinktank.CLI do
   defp emit(_command, payload) when is_binary(payload), do: IO.puts(payload)
   defp emit(_command, payload), do: IO.puts(Render.render_run_payload(payload))
 
+  defp emit_rendered(payload, %{json: true}, json_fun, _text_fun),
+    do: IO.puts(json_fun.(payload))
+
+  defp emit_rendered(payload, _command, _json_fun, text_fun), do: IO.puts(text_fun.(payload))
+
   defp emit_eval(%{json: true}, payload), do: IO.puts(Jason.encode!(payload))
   defp emit_eval(_command, payload), do: payload |> Render.eval_text() |> IO.puts()
 
@@ -386,12 +396,5 @@ defmodule Thinktank.CLI do
   defp maybe_put_opt(opts, _key, nil), do: opts
   defp maybe_put_opt(opts, key, value), do: Keyword.put(opts, key, value)
 
-  defp agent_config_dir(cwd) do
-    if Config.trust_repo_agent_config?() do
-      dir = Path.join(cwd, "agent_config")
-      if File.dir?(dir), do: dir
-    end
-  end
-
   defp version, do: Application.spec(:thinktank, :vsn) |> to_string()
 end
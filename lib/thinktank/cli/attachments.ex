@@ -0,0 +1,49 @@
+defmodule Thinktank.CLI.Attachments do
+  @moduledoc false
+
+  alias Thinktank.{AgentSpec, BenchSpec, Config}
+
+  # `--attach` images are handed to Pi as `@path` arguments, which Pi sends as
+  # image parts. Only agents configured with `vision: true` receive them; the
+  # rest run text-only and the CLI says which ones.
+  @extensions ~w(.png .jpg .jpeg .gif .webp)
+  @max_bytes 20 * 1024 * 1024
+
+  @spec validate([Path.t()]) :: :ok | {:error, atom(), String.t()}
+  def validate(paths) do
+    Enum.find_value(paths, :ok, fn path ->
+      case check(Path.expand(path)) do
+        :ok -> nil
+        {:error, reason} -> {:error, :invalid_attachment, "--attach #{path}: #{reason}"}
+      end
+    end)
+  end
+
+  @spec text_only_warnings([Path.t()], BenchSpec.t(), [String.t()], Config.t()) :: [String.t()]
+  def text_only_warnings([], _bench, _selected, _config), do: []
+
+  def text_only_warnings(_attachments, %BenchSpec{} = bench, selected, %Config{} = config) do
+    names = if selected == [], do: bench.agents, else: selected
+
+    case Enum.reject(names, &match?(%AgentSpec{vision: true}, config.agents[&1])) do
+      [] -> []
+      text_only -> ["attachments skipped for text-only agents: #{Enum.join(text_only, ", ")}"]
+    end
+  end
+
+  defp check(path) do
+    cond do
+      not File.regular?(path) ->
+        {:error, "not a readable file"}
+
+      String.downcase(Path.extname(path)) not in @extensions ->
+        {:error, "only #{Enum.join(@extensions, ", ")} images can be attached"}
+
+      File.stat!(path).size > @max_bytes ->
+        {:error, "larger than #{div(@max_bytes, 1024 * 1024)} MiB"}
+
+      true ->
+        :ok
+    end
+  end
+end
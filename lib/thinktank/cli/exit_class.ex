@@ -0,0 +1,30 @@
+defmodule Thinktank.CLI.ExitClass do
+  @moduledoc false
+
+  alias Thinktank.Error
+
+  # Classes name keys of `Thinktank.CLI.exit_codes/0`. `partial` runs split by
+  # cause: a timed-out agent or synthesizer reports `:timed_out`, otherwise the
+  # synthesizer failed after agents succeeded.
+  @spec for_run(map()) :: atom()
+  def for_run(%{envelope: %{status: "complete"}}), do: :success
+  def for_run(%{envelope: %{status: "degraded"}}), do: :degraded
+
+  def for_run(%{envelope: %{status: "partial"}} = run_result) do
+    if timed_out?(run_result), do: :timed_out, else: :synthesis_failed
+  end
+
+  def for_run(_run_result), do: :generic_error
+
+  @spec for_error(Error.t()) :: atom()
+  def for_error(%Error{code: code})
+      when code in [:no_successful_agents, :review_domain_coverage_missing],
+      do: :run_failed
+
+  def for_error(_error), do: :generic_error
+
+  defp timed_out?(run_result) do
+    [Map.get(run_result, :synthesis) | Map.get(run_result, :results, [])]
+    |> Enum.any?(&match?(%{status: :error, error: %{category: :timeout}}, &1))
+  end
+end
@@ -0,0 +1,94 @@
+defmodule Thinktank.CLI.FrontMatter do
+  @moduledoc false
+
+  # With `--front-matter`, task text may open with a YAML block of per-run
+  # settings, so a saved task file describes how it should be run:
+  #
+  #     ---
+  #     agents: [systems, dx]
+  #     no_synthesis: true
+  #     ---
+  #     The actual task...
+  #
+  # The block is stripped before the text reaches any prompt. Explicit flags
+  # win: `--agents` replaces front-matter agents, and front matter cannot turn
+  # `--no-synthesis` back off.
+  @keys ["agents", "no_synthesis"]
+  @block ~r/\A---[ \t]*\r?\n(.*?)\r?\n---[ \t]*(?:\r?\n|\z)/s
+
+  @spec extract(map()) :: {:ok, map()} | {:error, atom(), String.t()}
+  def extract(%{front_matter: true, input: %{input_text: text}} = command)
+      when is_binary(text) do
+    case Regex.run(@block, text) do
+      nil ->
+        {:ok, command}
+
+      [block, yaml] ->
+        rest = text |> binary_part(byte_size(block), byte_size(text) - byte_size(block))
+
+        with {:ok, settings} <- parse(yaml),
+             {:ok, rest} <- task_text(String.trim(rest)) do
+          {:ok, merge(command, settings, rest)}
+        end
+    end
+  end
+
+  def extract(command), do: {:ok, command}
+
+  defp parse(yaml) do
+    case YamlElixir.read_from_string(yaml) do
+      {:ok, %{} = settings} -> validate(settings)
+      {:ok, nil} -> {:ok, %{agents: [], no_synthesis: false}}
+      {:ok, _other} -> invalid("front matter must be a YAML mapping")
+      {:error, reason} -> invalid("front matter is not valid YAML: #{inspect(reason)}")
+    end
+  end
+
+  defp validate(settings) do
+    case Enum.reject(Map.keys(settings), &(&1 in @keys)) do
+      [] ->
+        with {:ok, agents} <- agents(settings["agents"]),
+             {:ok, no_synthesis} <- no_synthesis(settings["no_synthesis"]) do
+          {:ok, %{agents: agents, no_synthesis: no_synthesis}}
+        end
+
+      unknown ->
+        invalid(
+          "front matter supports #{Enum.join(@keys, ", ")}; unknown keys: " <>
+            Enum.map_join(unknown, ", ", &to_string/1)
+        )
+    end
+  end
+
+  defp agents(nil), do: {:ok, []}
+  defp agents(names) when is_binary(names), do: agents(String.split(names, ","))
+
+  defp agents(names) when is_list(names) do
+    if Enum.all?(names, &is_binary/1),
+      do: {:ok, names |> Enum.map(&String.trim/1) |> Enum.reject(&(&1 == ""))},
+      else: agents(:invalid)
+  end
+
+  defp agents(_names), do: invalid("front matter agents must be a list of agent names")
+
+  defp no_synthesis(nil), do: {:ok, false}
+  defp no_synthesis(value) when is_boolean(value), do: {:ok, value}
+  defp no_synthesis(_value), do: invalid("front matter no_synthesis must be true or false")
+
+  defp task_text(""), do: {:error, :missing_input_text, "input text is required"}
+  defp task_text(text), do: {:ok, text}
+
+  defp invalid(message), do: {:error, :invalid_front_matter, message}
+
+  defp merge(%{input: input} = command, settings, text) do
+    %{
+      command
+      | input: %{
+          input
+          | input_text: text,
+            agents: if(input.agents == [], do: settings.agents, else: input.agents),
+            no_synthesis: input.no_synthesis or settings.no_synthesis
+        }
+    }
+  end
+end
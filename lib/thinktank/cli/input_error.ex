@@ -0,0 +1,21 @@
+defmodule Thinktank.CLI.InputError do
+  @moduledoc false
+
+  alias Thinktank.Error
+
+  # The parser returns `{:error, code, message}` at the point of failure.
+  # Scripts should branch on the `code`, which stays stable when the wording
+  # of the human message changes.
+
+  @spec new(atom(), String.t()) :: Error.t()
+  def new(code, message) when is_atom(code) and is_binary(message) do
+    %Error{code: code, message: message, details: %{}}
+  end
+
+  # `--json` must shape even a parse failure, so it is read straight from argv.
+  @spec with_format(term(), [String.t()]) :: term()
+  def with_format({:error, code, message}, args) when is_atom(code) and is_binary(message),
+    do: {:error, code, message, %{json: "--json" in args}}
+
+  def with_format(other, _args), do: other
+end
@@ -0,0 +1,54 @@
+defmodule Thinktank.CLI.InputPaths do
+  @moduledoc false
+
+  # Blank-file detection reads at most this prefix of each file, so a large
+  # file counts as blank when its first bytes are all whitespace.
+  @blank_probe_bytes 4_096
+
+  @spec validate([Path.t()], boolean()) :: :ok | {:error, atom(), String.t()}
+  def validate([], _allow_empty), do: :ok
+
+  def validate(paths, allow_empty) do
+    case Enum.reject(paths, &exists?/1) do
+      [] ->
+        if allow_empty or Enum.any?(paths, &content?/1) do
+          :ok
+        else
+          {:error, :empty_paths,
+           "no content found under --paths #{Enum.join(paths, ", ")} " <>
+             "(pass --allow-empty to run anyway)"}
+        end
+
+      missing ->
+        {:error, :missing_paths, "--paths entries do not exist: #{Enum.join(missing, ", ")}"}
+    end
+  end
+
+  defp exists?(path), do: match?({:ok, _stat}, File.lstat(path))
+
+  # Symlinks are not followed, so a link loop cannot hang the check; a link
+  # counts as content because the agent will resolve it.
+  defp content?(path) do
+    case File.lstat(path) do
+      {:ok, %File.Stat{type: :regular, size: 0}} -> false
+      {:ok, %File.Stat{type: :regular}} -> not blank_file?(path)
+      {:ok, %File.Stat{type: :directory}} -> directory_content?(path)
+      {:ok, %File.Stat{type: :symlink}} -> true
+      _ -> false
+    end
+  end
+
+  defp directory_content?(path) do
+    case File.ls(path) do
+      {:ok, entries} -> Enum.any?(entries, &content?(Path.join(path, &1)))
+      {:error, _reason} -> false
+    end
+  end
+
+  defp blank_file?(path) do
+    case File.open(path, [:read, :binary], &IO.binread(&1, @blank_probe_bytes)) do
+      {:ok, prefix} when is_binary(prefix) -> String.match?(prefix, ~r/\A\s*\z/)
+      _other -> false
+    end
+  end
+end
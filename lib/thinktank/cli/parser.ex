@@ -2,6 +2,7 @@ defmodule Thinktank.CLI.Parser do
   @moduledoc false
 
   alias Thinktank.{BenchSpec, Config}
+  alias Thinktank.CLI.{Attachments, FrontMatter, InputPaths, SecretPaths}
 
   @option_spec [
     strict: [
@@ -9,14 +10,31 @@ defmodule Thinktank.CLI.Parser do
       version: :boolean,
       input: :string,
       paths: :keep,
+      paths_from: :string,
+      refuse_secrets: :boolean,
+      allow_empty: :boolean,
       agents: :string,
+      allow_duplicates: :boolean,
       bench: :string,
       json: :boolean,
       full: :boolean,
       output: :string,
       dry_run: :boolean,
+      validate: :boolean,
+      print_prompt: :boolean,
+      print_config: :boolean,
       no_synthesis: :boolean,
+      front_matter: :boolean,
+      allow_empty_response: :boolean,
+      capture_requests: :boolean,
+      attach: :keep,
+      stream: :boolean,
+      quiet: :boolean,
+      progress: :string,
+      summary_file: :string,
+      tag: :keep,
       trust_repo_config: :boolean,
+      env_file: :string,
       base: :string,
       head: :string,
       repo: :string,
@@ -26,13 +44,18 @@ defmodule Thinktank.CLI.Parser do
     aliases: [
       h: :help,
       v: :version,
+      q: :quiet,
       o: :output
     ]
   ]
 
+  @progress_modes ["always", "never", "auto"]
+  @tag_key ~r/^[A-Za-z][A-Za-z0-9_.-]{0,63}$/
+  @invalid_utf8_warning "input text contained invalid UTF-8; bad bytes were replaced with U+FFFD"
+
   @spec parse_args([String.t()]) ::
           {:ok, map()}
-          | {:error, String.t()}
+          | {:error, atom(), String.t()}
           | {:help, map()}
           | {:version, map()}
           | {:needs_stdin, map()}
@@ -42,61 +65,81 @@ defmodule Thinktank.CLI.Parser do
     cond do
       invalid != [] ->
         [{flag, _} | _] = invalid
-        {:error, "unknown flag: #{flag}"}
+        {:error, :unknown_flag, "unknown flag: #{flag}"}
+
+      parsed[:progress] not in [nil | @progress_modes] ->
+        {:error, :invalid_flag_value, "--progress must be always, never, or auto"}
 
       parsed[:help] ->
         {:help, %{}}
 
       parsed[:version] ->
-        {:version, %{}}
-
-      rest == [] ->
-        build_fixed_bench_command(
-          "research/default",
-          parsed,
-          resolve_input_text(parsed[:input], [])
-        )
+        {:version, %{json: parsed[:json] || false, trust_repo_config: parsed[:trust_repo_config]}}
 
       true ->
-        build_command(rest, parsed)
+        rest |> build_command(parsed) |> put_env_file(env_file(parsed))
     end
   end
 
-  @spec read_stdin(map(), keyword()) :: {:ok, map()} | {:error, String.t()}
-  def read_stdin(command, opts \\ []) do
-    if stdin_piped?(opts) do
-      input =
-        opts
-        |> Keyword.get(:reader, &IO.read/2)
-        |> then(& &1.(:stdio, :eof))
-        |> case do
-          data when is_binary(data) -> String.trim(data)
-          _ -> ""
-        end
+  @spec read_stdin(map(), keyword()) :: {:ok, map()} | {:error, atom(), String.t()}
+  def read_stdin(command, opts \\ [])
 
-      if input == "" do
-        {:error, "input text is required"}
-      else
-        {:ok, put_in(command.input.input_text, input)}
-      end
+  # With `--paths-from -` the piped data is the path list; the bench command
+  # is built once the list has been read and added to the `--paths` flags.
+  def read_stdin(%{stdin: :paths_from} = pending, opts) do
+    case read_piped(opts) do
+      {:ok, body} ->
+        parsed =
+          Keyword.delete(pending.parsed, :paths_from) ++
+            Enum.map(path_lines(body), &{:paths, &1})
+
+        pending.bench_id
+        |> build_bench_command(parsed, pending.input_text)
+        |> put_env_file(Map.get(pending, :env_file))
+
+      :not_piped ->
+        paths_from_error("-: nothing was piped to stdin")
+    end
+  end
+
+  def read_stdin(command, opts) do
+    with {:ok, raw} <- read_piped(opts),
+         {input, warnings} = repair_utf8(raw),
+         input when input != "" <- String.trim(input) do
+      command
+      |> put_in([:input, :input_text], input)
+      |> put_warnings(warnings)
+      |> finish_run_command()
     else
-      {:error, "input text is required"}
+      _missing -> {:error, :missing_input_text, "input text is required"}
     end
   end
 
-  defp build_command(["run", bench_id | remainder], parsed) do
-    with {:ok, config, bench} <- resolve_bench(bench_id, parsed),
-         :ok <- validate_review_pr_flags(bench, parsed) do
-      input_text = resolve_input_text(parsed[:input], remainder)
+  @spec benches_validate_opts(map()) :: keyword()
+  def benches_validate_opts(command) do
+    [
+      capability_probe: Map.get(command, :capability_probe),
+      env_reader: Map.get(command, :capability_env_reader),
+      http_requester: Map.get(command, :capability_http_requester),
+      max_concurrency: Map.get(command, :capability_max_concurrency),
+      probe_timeout_ms: Map.get(command, :capability_probe_timeout_ms)
+    ]
+    |> reject_nil_opts()
+  end
 
-      if input_text == nil and needs_stdin?(bench) do
-        {:needs_stdin, build_run_command(bench, parsed, nil, config)}
-      else
-        {:ok, build_run_command(bench, parsed, input_text, config)}
-      end
-    end
+  @spec runs_wait_opts(map()) :: keyword()
+  def runs_wait_opts(command), do: reject_nil_opts(timeout_ms: Map.get(command, :timeout_ms))
+
+  @spec runs_list_opts(map()) :: keyword()
+  def runs_list_opts(command), do: reject_nil_opts(limit: Map.get(command, :limit))
+
+  defp build_command([], parsed) do
+    build_bench_command("research/default", parsed, resolve_input_text(parsed[:input], []))
   end
 
+  defp build_command(["run", bench_id | remainder], parsed),
+    do: build_bench_command(bench_id, parsed, resolve_input_text(parsed[:input], remainder))
+
   defp build_command(["review", "eval", target], parsed) do
     {:ok,
      %{
@@ -110,27 +153,20 @@ defmodule Thinktank.CLI.Parser do
      }}
   end
 
-  defp build_command(["review", "eval"], _parsed), do: {:error, "review eval requires a path"}
+  defp build_command(["review", "eval"], _parsed),
+    do: {:error, :invalid_command, "review eval requires a path"}
 
   defp build_command(["review" | remainder], parsed) do
-    with {:ok, config, bench} <- resolve_bench("review/default", parsed),
-         :ok <- validate_review_pr_flags(bench, parsed) do
-      input_text = resolve_input_text(parsed[:input], remainder)
-
-      if input_text == nil and needs_stdin?(bench) do
-        {:needs_stdin, build_run_command(bench, parsed, nil, config)}
-      else
-        {:ok, build_run_command(bench, parsed, input_text, config)}
-      end
-    end
+    input_text = resolve_input_text(parsed[:input], remainder)
+    build_bench_command("review/default", parsed, input_text)
   end
 
   defp build_command(["research" | remainder], parsed) do
     input_text = resolve_input_text(parsed[:input], remainder)
-    build_fixed_bench_command("research/default", parsed, input_text)
+    build_bench_command("research/default", parsed, input_text)
   end
 
-  defp build_command(["run"], _parsed), do: {:error, "run requires a bench id"}
+  defp build_command(["run"], _parsed), do: {:error, :missing_bench, "run requires a bench id"}
 
   defp build_command([group, "list"], parsed) when group in ["benches", "workflows"] do
     {:ok,
@@ -175,7 +211,7 @@ defmodule Thinktank.CLI.Parser do
   end
 
   defp build_command(["runs" | _rest], _parsed) do
-    {:error, "runs expects list, show <path-or-id>, or wait <path-or-id>"}
+    {:error, :invalid_command, "runs expects list, show <path-or-id>, or wait <path-or-id>"}
   end
 
   defp build_command([group, "show", bench_id], parsed) when group in ["benches", "workflows"] do
@@ -201,20 +237,39 @@ defmodule Thinktank.CLI.Parser do
   end
 
   defp build_command([group | _rest], _parsed) when group in ["benches", "workflows"] do
-    {:error, "#{group} expects list, show <bench>, or validate"}
+    {:error, :invalid_command, "#{group} expects list, show <bench>, or validate"}
+  end
+
+  defp build_command(["agents", "list"], parsed) do
+    {:ok,
+     %{
+       action: :agents_list,
+       cwd: File.cwd!(),
+       json: parsed[:json] || false,
+       trust_repo_config: parsed[:trust_repo_config]
+     }}
   end
 
   defp build_command(rest, parsed) do
-    build_fixed_bench_command("research/default", parsed, Enum.join(rest, " "))
+    build_bench_command("research/default", parsed, Enum.join(rest, " "))
   end
 
-  defp build_fixed_bench_command(bench_id, parsed, input_text) do
+  # Path, secret, attachment, and tag checks only apply to commands that
+  # launch a bench; listing and inspection commands ignore those flags.
+  defp build_bench_command(bench_id, parsed, input_text) do
     with {:ok, config, bench} <- resolve_bench(bench_id, parsed),
-         :ok <- validate_review_pr_flags(bench, parsed) do
-      if input_text == nil and needs_stdin?(bench) do
+         :ok <- validate_review_pr_flags(bench, parsed),
+         stdin_task? = input_text == nil and needs_stdin?(bench),
+         :ok <- paths_from_stdin(parsed, bench_id, input_text, stdin_task?),
+         {:ok, parsed} <- expand_paths_from(parsed),
+         :ok <- validate_input_paths(parsed),
+         :ok <- refuse_secret_paths(parsed),
+         :ok <- Attachments.validate(Keyword.get_values(parsed, :attach)),
+         :ok <- validate_tags(parsed) do
+      if stdin_task? do
         {:needs_stdin, build_run_command(bench, parsed, nil, config)}
       else
-        {:ok, build_run_command(bench, parsed, input_text, config)}
+        finish_run_command(build_run_command(bench, parsed, input_text, config))
       end
     end
   end
@@ -230,21 +285,63 @@ defmodule Thinktank.CLI.Parser do
     end
   end
 
+  # Front matter can replace the agent list once the task text is known, so
+  # duplicate and text-only checks wait for the final list.
+  defp finish_run_command(command) do
+    with {:ok, command} <- FrontMatter.extract(command) do
+      {agents, duplicates} = dedupe_agents(command.input.agents, command.allow_duplicates)
+      {:ok, bench} = Config.bench(command.config, command.bench_id)
+
+      text_only =
+        command.input
+        |> Map.get(:attachments, [])
+        |> Attachments.text_only_warnings(bench, agents, command.config)
+
+      {:ok,
+       command
+       |> put_in([:input, :agents], agents)
+       |> put_warnings(Enum.map(duplicates, &duplicate_agent_warning/1) ++ text_only)}
+    end
+  end
+
   defp build_common_command(parsed, bench_id, input_text) do
+    paths = normalize_paths(Keyword.get_values(parsed, :paths))
+    {input_text, utf8_warnings} = repair_utf8(input_text)
+
+    warnings =
+      if parsed[:quiet],
+        do: [],
+        else: secret_path_warnings(paths) ++ utf8_warnings
+
     %{
       action: :run,
       bench_id: bench_id,
       cwd: File.cwd!(),
       json: parsed[:json] || false,
       output: parsed[:output] && Path.expand(parsed[:output]),
-      dry_run: parsed[:dry_run] || false,
+      dry_run: parsed[:dry_run] || parsed[:validate] || false,
+      validate: parsed[:validate] || false,
+      print_prompt: parsed[:print_prompt] || false,
+      print_config: parsed[:print_config] || false,
+      stream: parsed[:stream] || false,
+      front_matter: parsed[:front_matter] || false,
+      allow_duplicates: parsed[:allow_duplicates] || false,
+      allow_empty_response: parsed[:allow_empty_response] || false,
+      capture_requests: parsed[:capture_requests] || false,
+      summary_file: parsed[:summary_file] && Path.expand(parsed[:summary_file]),
       trust_repo_config: parsed[:trust_repo_config],
-      input: %{
-        input_text: input_text,
-        paths: normalize_paths(Keyword.get_values(parsed, :paths)),
-        agents: parse_agent_list(parsed[:agents]),
-        no_synthesis: parsed[:no_synthesis] || false
-      }
+      log_level: if(parsed[:quiet], do: :error),
+      text_progress: text_progress?(parsed),
+      warnings: warnings,
+      input:
+        %{
+          input_text: input_text,
+          paths: paths,
+          agents: parse_agent_list(parsed[:agents]),
+          no_synthesis: parsed[:no_synthesis] || false
+        }
+        |> maybe_put_value(:tags, tag_map(parsed))
+        |> maybe_put_value(:attachments, attachments(parsed))
     }
   end
 
@@ -261,7 +358,8 @@ defmodule Thinktank.CLI.Parser do
   defp parse_timeout_ms(timeout_ms) when is_integer(timeout_ms) and timeout_ms >= 0,
     do: {:ok, timeout_ms}
 
-  defp parse_timeout_ms(_timeout_ms), do: {:error, "--timeout-ms must be a non-negative integer"}
+  defp parse_timeout_ms(_timeout_ms),
+    do: {:error, :invalid_flag_value, "--timeout-ms must be a non-negative integer"}
 
   defp validate_review_pr_flags(%BenchSpec{id: bench_id} = bench, parsed) do
     parsed = if is_map(parsed), do: parsed, else: Map.new(parsed)
@@ -271,7 +369,7 @@ defmodule Thinktank.CLI.Parser do
         :ok
 
       parsed[:pr] && !parsed[:repo] ->
-        {:error, "#{bench_id} requires --repo when --pr is provided"}
+        {:error, :invalid_flag_value, "#{bench_id} requires --repo when --pr is provided"}
 
       true ->
         :ok
@@ -279,17 +377,37 @@ defmodule Thinktank.CLI.Parser do
   end
 
   defp resolve_bench(bench_id, parsed) do
-    with {:ok, config} <-
-           Config.load(cwd: File.cwd!(), trust_repo_config: parsed[:trust_repo_config]) do
-      case Config.bench(config, bench_id) do
-        {:ok, bench} -> {:ok, config, bench}
-        {:error, reason} -> {:error, reason}
-      end
+    case Config.load(cwd: File.cwd!(), trust_repo_config: parsed[:trust_repo_config]) do
+      {:ok, config} ->
+        case Config.bench(config, bench_id) do
+          {:ok, bench} -> {:ok, config, bench}
+          {:error, reason} -> {:error, :unknown_bench, reason}
+        end
+
+      {:error, reason} ->
+        {:error, :invalid_config, reason}
     end
   end
 
   defp needs_stdin?(%BenchSpec{default_task: default_task}), do: is_nil(default_task)
 
+  # `--paths-from -` defers the bench command to `read_stdin/2`, which reads
+  # the list; stdin cannot carry the task text as well.
+  defp paths_from_stdin(parsed, bench_id, input_text, stdin_task?) do
+    cond do
+      parsed[:paths_from] != "-" ->
+        :ok
+
+      stdin_task? ->
+        {:error, :invalid_flag_value,
+         "--paths-from - reads stdin, so the task text must come from --input or an argument"}
+
+      true ->
+        {:needs_stdin,
+         %{stdin: :paths_from, bench_id: bench_id, parsed: parsed, input_text: input_text}}
+    end
+  end
+
   defp review_bench?(%BenchSpec{kind: :review}), do: true
   defp review_bench?(_), do: false
 
@@ -310,9 +428,177 @@ defmodule Thinktank.CLI.Parser do
 
   defp parse_agent_list(_), do: []
 
+  defp dedupe_agents(agents, true = _allow_duplicates), do: {agents, []}
+
+  defp dedupe_agents(agents, false = _allow_duplicates) do
+    unique = Enum.uniq(agents)
+    {unique, Enum.uniq(agents -- unique)}
+  end
+
+  defp duplicate_agent_warning(name) do
+    "agent #{name} was listed more than once; running it once " <>
+      "(pass --allow-duplicates to run every listed instance)"
+  end
+
+  # --paths-from appends one path per line after any --paths flags; blank
+  # lines and # comments are skipped. "-" is read later, by `read_stdin/2`.
+  defp expand_paths_from(parsed) do
+    case parsed[:paths_from] do
+      nil ->
+        {:ok, parsed}
+
+      path ->
+        case File.read(Path.expand(path)) do
+          {:ok, body} -> {:ok, parsed ++ Enum.map(path_lines(body), &{:paths, &1})}
+          {:error, reason} -> paths_from_error("#{path}: #{:file.format_error(reason)}")
+        end
+    end
+  end
+
+  defp path_lines(body) do
+    body
+    |> String.split("\n")
+    |> Enum.map(&String.trim/1)
+    |> Enum.reject(&(&1 == "" or String.starts_with?(&1, "#")))
+  end
+
+  defp paths_from_error(reason),
+    do: {:error, :unreadable_paths_from, "cannot read --paths-from #{reason}"}
+
+  defp validate_input_paths(parsed) do
+    parsed
+    |> Keyword.get_values(:paths)
+    |> normalize_paths()
+    |> InputPaths.validate(parsed[:allow_empty] || false)
+  end
+
+  # Invalid UTF-8 in the task text would be copied verbatim into prompt files
+  # and JSON artifacts. Bad bytes become U+FFFD and the run says so.
+  defp repair_utf8(nil), do: {nil, []}
+
+  defp repair_utf8(text) do
+    if String.valid?(text),
+      do: {text, []},
+      else: {String.replace_invalid(text), [@invalid_utf8_warning]}
+  end
+
+  # `--quiet` commands carry the error log level and drop every warning.
+  defp put_warnings(command, []), do: command
+  defp put_warnings(%{log_level: :error} = command, _warnings), do: command
+
+  defp put_warnings(command, warnings),
+    do: Map.update(command, :warnings, warnings, &(&1 ++ warnings))
+
+  defp secret_path_warnings(paths) do
+    case SecretPaths.matches(paths) do
+      [] -> []
+      matches -> [SecretPaths.warning(matches)]
+    end
+  end
+
+  defp refuse_secret_paths(parsed) do
+    matches =
+      if parsed[:refuse_secrets],
+        do: parsed |> Keyword.get_values(:paths) |> normalize_paths() |> SecretPaths.matches(),
+        else: []
+
+    case matches do
+      [] ->
+        :ok
+
+      matches ->
+        {:error, :secret_paths, "refusing secret-looking paths: #{Enum.join(matches, ", ")}"}
+    end
+  end
+
+  defp validate_tags(parsed) do
+    case Enum.reject(Keyword.get_values(parsed, :tag), &valid_tag?/1) do
+      [] ->
+        :ok
+
+      [tag | _] ->
+        {:error, :invalid_flag_value,
+         "--tag must be key=value with a key of letters, digits, '_', '.', or '-': #{tag}"}
+    end
+  end
+
+  defp valid_tag?(tag) do
+    case String.split(tag, "=", parts: 2) do
+      [key, value] -> Regex.match?(@tag_key, key) and String.trim(value) != ""
+      _ -> false
+    end
+  end
+
+  # Repeated keys keep the last value, like repeated environment assignments.
+  defp tag_map(parsed) do
+    case Keyword.get_values(parsed, :tag) do
+      [] ->
+        nil
+
+      tags ->
+        Map.new(tags, fn tag ->
+          [key, value] = String.split(tag, "=", parts: 2)
+          {key, String.trim(value)}
+        end)
+    end
+  end
+
+  defp attachments(parsed) do
+    case Keyword.get_values(parsed, :attach) do
+      [] -> nil
+      paths -> Enum.map(paths, &Path.expand/1)
+    end
+  end
+
+  # An explicit --env-file always loads; the workspace .env only loads when
+  # repo config is trusted, since it can set THINKTANK_* switches. The file is
+  # only named here: `CLI.execute/1` loads it, so parsing has no side effects.
+  defp env_file(parsed) do
+    cond do
+      parsed[:env_file] ->
+        Path.expand(parsed[:env_file])
+
+      Config.trust_repo_config?(parsed[:trust_repo_config]) ->
+        path = Path.join(File.cwd!(), ".env")
+        if File.regular?(path), do: path
+
+      true ->
+        nil
+    end
+  end
+
+  defp put_env_file(result, nil), do: result
+
+  defp put_env_file({tag, command}, path) when tag in [:ok, :needs_stdin],
+    do: {tag, Map.put(command, :env_file, path)}
+
+  defp put_env_file(result, _path), do: result
+
+  defp text_progress?(parsed) do
+    cond do
+      parsed[:quiet] or parsed[:json] -> false
+      parsed[:progress] == "always" -> true
+      parsed[:progress] == "never" -> false
+      true -> match?({:ok, _columns}, :io.columns(:standard_error))
+    end
+  end
+
+  defp reject_nil_opts(opts), do: Enum.reject(opts, fn {_key, value} -> is_nil(value) end)
+
   defp maybe_put_value(map, _key, nil), do: map
   defp maybe_put_value(map, key, value), do: Map.put(map, key, value)
 
+  defp read_piped(opts) do
+    if stdin_piped?(opts) do
+      case Keyword.get(opts, :reader, &IO.read/2).(:stdio, :eof) do
+        data when is_binary(data) -> {:ok, data}
+        _eof_or_error -> {:ok, ""}
+      end
+    else
+      :not_piped
+    end
+  end
+
   defp stdin_piped?(opts) do
     case Keyword.get(opts, :stdin_piped?, &stdin_piped?/0) do
       fun when is_function(fun, 0) -> fun.()
@@ -1,7 +1,8 @@
 defmodule Thinktank.CLI.Render do
   @moduledoc false
 
-  alias Thinktank.{AgentSpec, Error}
+  alias Thinktank.{AgentSpec, Config, Error, Pricing}
+  alias Thinktank.CLI.RunSummary
 
   @spec usage_text(String.t()) :: String.t()
   def usage_text(version) do
@@ -15,24 +16,43 @@ defmodule Thinktank.CLI.Render do
       thinktank review eval <contract-or-dir> [--bench <bench>]
       thinktank runs list|show <path-or-id>|wait <path-or-id> [--timeout-ms N]
       thinktank benches list|show|validate
+      thinktank agents list
 
     Task text can come from --input, positional text, or piped stdin.
 
     Options:
       --input TEXT          Task text
       --paths PATH          Point the bench at paths in the workspace (repeatable)
+      --paths-from FILE     Read more paths, one per line, from FILE (- for stdin)
+      --attach IMAGE        Attach an image for agents with vision: true (repeatable)
+      --allow-empty         Run even when every --paths entry is empty
+      --allow-empty-response  Accept an agent that exits cleanly with blank output
+      --capture-requests    Record request parameters in failures/*.json
+      --refuse-secrets      Fail instead of warning when --paths names secret-looking files
       --agents LIST         Comma-separated agent override for the selected bench
+      --allow-duplicates    Keep repeated --agents entries as separate runs
       --json                Output JSON
       --full                Include full agent specs in benches show
       --output, -o DIR      Output directory
       --dry-run             Resolve the bench without launching agents
+      --validate            Dry run that also fails when a provider key is unset
+      --print-prompt        Print each agent's rendered prompt without launching agents
+      --print-config        Print the resolved run configuration without launching agents
       --no-synthesis        Skip the synthesizer agent
+      --front-matter        Read agents/no_synthesis from a leading YAML block in the task text
+      --stream              Echo agent output to stderr as it arrives
+      --summary-file PATH   Write a compact JSON run summary to PATH
+      --tag KEY=VALUE       Attach a tag to every trace event and the run summary (repeatable)
+      --quiet, -q           Only report errors on stderr
+      --progress MODE       Per-agent status lines on stderr: auto, always, or never
       --trust-repo-config   Trust .thinktank/config.yml in the current repository
+      --env-file PATH       Load unset environment variables from a dotenv file
       --base REF            Review base ref
       --head REF            Review head ref
       --repo REPO           Review repo owner/name
       --pr N                Review pull request number
       --timeout-ms N        Bound runs wait polling in milliseconds
+      --version, -v         Print version, build commit, and builtin config hash
 
     Examples:
       thinktank research "analyze this codebase" --paths ./lib
@@ -73,6 +93,82 @@ defmodule Thinktank.CLI.Render do
     end
   end
 
+  @spec progress_line(String.t(), map(), non_neg_integer()) :: String.t() | nil
+  def progress_line(event, attrs, elapsed_ms) do
+    case progress_message(event, attrs) do
+      nil -> nil
+      message -> "[#{Float.round(elapsed_ms / 1000, 1)}s] #{message}"
+    end
+  end
+
+  defp progress_message("agents_started", %{"total_agents" => total}),
+    do: "running #{total} agent(s)"
+
+  defp progress_message("agent_started", %{"agent_name" => name}), do: "#{name}: running"
+
+  defp progress_message("agent_retrying", %{"agent_name" => name} = attrs),
+    do: "#{name}: retrying (attempt #{attrs["attempt"]}/#{attrs["max_attempts"]})"
+
+  defp progress_message("agent_finished", %{"agent_name" => name, "status" => "ok"}),
+    do: "#{name}: done"
+
+  defp progress_message("agent_finished", %{"agent_name" => name}), do: "#{name}: failed"
+  defp progress_message("synthesis_started", _attrs), do: "synthesizing"
+  defp progress_message("run_completed", %{"status" => status}), do: "run #{status}"
+  defp progress_message(_event, _attrs), do: nil
+
+  @spec warning_line(map(), String.t()) :: String.t()
+  def warning_line(%{json: true}, warning),
+    do: Jason.encode!(%{event: "warning", message: warning})
+
+  def warning_line(_command, warning), do: "Warning: #{warning}"
+
+  @spec prompts_json([map()]) :: String.t()
+  def prompts_json(prompts), do: Jason.encode!(%{prompts: prompts})
+
+  @spec prompts_text([map()]) :: String.t()
+  def prompts_text(prompts) do
+    Enum.map_join(prompts, "\n\n", fn %{agent: agent, prompt: prompt} ->
+      "==> #{agent} <==\n#{prompt}"
+    end)
+  end
+
+  @spec listing(atom(), Config.t()) ::
+          {[map()], ([map()] -> String.t()), ([map()] -> String.t())}
+  def listing(:benches_list, config),
+    do: {Config.list_benches(config), &benches_list_json/1, &benches_list_text/1}
+
+  def listing(:agents_list, config),
+    do: {agents_list(config), &Jason.encode!/1, &agents_list_text/1}
+
+  @spec agents_list_text([map()]) :: String.t()
+  def agents_list_text(agents) do
+    Enum.map_join(agents, "\n", fn agent ->
+      "#{agent.name}\t#{agent.provider}\t#{agent.model}\t#{pricing_text(agent.pricing)}"
+    end)
+  end
+
+  # Every agent the merged config defines, builtin or user/repo supplied, with
+  # the USD per-million-token rate ThinkTank uses for its cost estimates.
+  defp agents_list(config) do
+    config.agents
+    |> Map.values()
+    |> Enum.sort_by(& &1.name)
+    |> Enum.map(fn agent ->
+      %{
+        name: agent.name,
+        provider: agent.provider,
+        model: agent.model,
+        fallback_model: agent.fallback_model,
+        thinking_level: agent.thinking_level,
+        pricing: Pricing.rate_for(agent.model)
+      }
+    end)
+  end
+
+  defp pricing_text(nil), do: "unpriced"
+  defp pricing_text(rate), do: "$#{rate.input} in / $#{rate.output} out per 1M tokens"
+
   @spec benches_list_json([map()]) :: String.t()
   def benches_list_json(benches) do
     benches
@@ -110,6 +206,21 @@ defmodule Thinktank.CLI.Render do
     |> Enum.join("\n\n")
   end
 
+  @spec benches_show_payload(map(), [term()]) :: map()
+  def benches_show_payload(bench, agents_payload) do
+    %{
+      id: bench.id,
+      description: bench.description,
+      kind: bench.kind,
+      structured_findings: bench.structured_findings,
+      agents: agents_payload,
+      planner: bench.planner,
+      synthesizer: bench.synthesizer,
+      concurrency: bench.concurrency,
+      default_task: bench.default_task
+    }
+  end
+
   @spec benches_show_json(map()) :: String.t()
   def benches_show_json(payload), do: Jason.encode!(payload, pretty: true)
 
@@ -234,6 +345,7 @@ defmodule Thinktank.CLI.Render do
     Status: #{payload.status}
     Output: #{payload.output_dir}
     Cost: #{render_usd_cost(payload[:usd_cost_total], payload[:pricing_gaps] || [])}
+    Tokens: #{render_tokens(RunSummary.usage_total(payload[:usd_cost_by_model]))}
 
     Agents:
     #{render_agent_lines(payload.agents)}
@@ -312,6 +424,12 @@ defmodule Thinktank.CLI.Render do
     end)
   end
 
+  defp render_tokens(usage) do
+    "input=#{usage["input_tokens"]} output=#{usage["output_tokens"]} " <>
+      "cache_read=#{usage["cache_read_tokens"]} cache_write=#{usage["cache_write_tokens"]} " <>
+      "total=#{usage["total_tokens"]}"
+  end
+
   defp render_usd_cost(total, []), do: "$" <> format_usd(total)
 
   defp render_usd_cost(_total, pricing_gaps) do
@@ -0,0 +1,113 @@
+defmodule Thinktank.CLI.ResolvedConfig do
+  @moduledoc false
+
+  alias Thinktank.{AgentSpec, Config}
+  alias Thinktank.Executor.ProviderEnv
+
+  @spec render(map(), map()) :: String.t()
+  def render(command, resolved) do
+    payload = build(command, resolved)
+    if command.json, do: Jason.encode!(payload), else: text(payload)
+  end
+
+  # Everything a run would use after bench expansion, `--agents` filtering,
+  # and config merging. Credentials are reported as set or unset, never by value.
+  @spec build(map(), map()) :: map()
+  def build(command, resolved) do
+    agents = resolved.agents
+    support = Enum.reject([resolved.planner, resolved.synthesizer], &is_nil/1)
+    sources = resolved.config.sources || %{}
+
+    %{
+      bench: resolved.bench.id,
+      kind: resolved.bench.kind,
+      concurrency: resolved.bench.concurrency,
+      output_dir: resolved.output_dir,
+      input: Map.take(resolved.contract.input, ["input_text", "paths", "no_synthesis"]),
+      agents: Enum.map(agents, &agent_payload/1),
+      planner: resolved.planner && agent_payload(resolved.planner),
+      synthesizer: resolved.synthesizer && agent_payload(resolved.synthesizer),
+      providers: providers_payload(resolved.config, agents ++ support),
+      config_sources: %{
+        user: sources[:user],
+        repo: sources[:repo],
+        repo_trusted: Config.trust_repo_config?(command.trust_repo_config)
+      }
+    }
+  end
+
+  defp agent_payload(%AgentSpec{} = agent) do
+    Map.take(agent, [
+      :name,
+      :provider,
+      :model,
+      :fallback_model,
+      :thinking_level,
+      :tools,
+      :timeout_ms,
+      :retries,
+      :retry_on,
+      :retry_delay_ms,
+      :retry_multiplier,
+      :retry_max_delay_ms
+    ])
+  end
+
+  defp providers_payload(config, agents) do
+    agents
+    |> Enum.map(& &1.provider)
+    |> Enum.uniq()
+    |> Map.new(fn id ->
+      provider = Map.get(config.providers, id)
+
+      {id,
+       %{
+         adapter: provider && provider.adapter,
+         credential_env: provider && provider.credential_env,
+         credential: credential_status(provider)
+       }}
+    end)
+  end
+
+  defp credential_status(%{credential_env: nil}), do: "not required"
+
+  defp credential_status(provider) do
+    if ProviderEnv.credentials(provider) == [], do: "unset", else: "set"
+  end
+
+  defp text(payload) do
+    sources = payload.config_sources
+    trust = if sources.repo_trusted, do: "trusted", else: "untrusted"
+
+    [
+      "Bench: #{payload.bench} (#{payload.kind})",
+      "Output: #{payload.output_dir}",
+      "Concurrency: #{payload.concurrency || "default"}",
+      "Paths: #{payload.input |> Map.get("paths") |> List.wrap() |> Enum.join(", ")}",
+      "User config: #{sources.user}",
+      "Repo config: #{sources.repo} (#{trust})",
+      "Agents:"
+    ]
+    |> Kernel.++(Enum.map(payload.agents, &("  " <> agent_line(&1))))
+    |> Kernel.++([
+      "Planner: #{support_line(payload.planner)}",
+      "Synthesizer: #{support_line(payload.synthesizer)}",
+      "Providers:"
+    ])
+    |> Kernel.++(Enum.map(Enum.sort(payload.providers), &provider_line/1))
+    |> Enum.join("\n")
+  end
+
+  defp support_line(nil), do: "none"
+  defp support_line(agent), do: agent_line(agent)
+
+  defp agent_line(agent) do
+    "#{agent.name} #{agent.provider}/#{agent.model} thinking=#{agent.thinking_level} " <>
+      "timeout_ms=#{agent.timeout_ms} retries=#{agent.retries}"
+  end
+
+  defp provider_line({id, provider}) do
+    "  #{id} adapter=#{provider.adapter} credential_env=#{provider.credential_env || "none"} " <>
+      "(#{provider.credential})"
+  end
+end
@@ -0,0 +1,168 @@
+defmodule Thinktank.CLI.RunSummary do
+  @moduledoc false
+
+  alias Thinktank.{ArtifactLayout, RunStore, TraceLog}
+
+  @usage_keys ~w(input_tokens output_tokens cache_read_tokens cache_write_tokens total_tokens)
+
+  # The bench has already finished when the summary is written, so a bad path
+  # is reported instead of raised and the run keeps its exit code. The file is
+  # renamed into place, so readers never see a half-written summary.
+  @spec write(Path.t(), Path.t() | nil, atom(), non_neg_integer()) :: :ok | {:error, String.t()}
+  def write(path, output_dir, exit_class, exit_code) do
+    summary = output_dir |> envelope() |> build(exit_class, exit_code)
+    tmp = path <> ".tmp"
+
+    with :ok <- File.mkdir_p(Path.dirname(path)),
+         :ok <- File.write(tmp, Jason.encode!(summary, pretty: true)),
+         :ok <- File.rename(tmp, path) do
+      :ok
+    else
+      {:error, reason} ->
+        File.rm(tmp)
+        {:error, "could not write --summary-file #{path}: #{:file.format_error(reason)}"}
+    end
+  end
+
+  @spec build(map() | nil, atom(), non_neg_integer()) :: map()
+  def build(nil, exit_class, exit_code) do
+    %{
+      status: "failed",
+      exit_class: exit_class,
+      exit_code: exit_code,
+      output_dir: nil,
+      synthesis_skipped: false,
+      tags: %{},
+      agents: [],
+      usage_total: usage_total(nil),
+      latency_by_model: %{}
+    }
+  end
+
+  def build(envelope, exit_class, exit_code) do
+    agents = Enum.map(envelope.agents || [], &agent_summary/1)
+
+    %{
+      bench: envelope.bench,
+      status: envelope.status,
+      exit_class: exit_class,
+      exit_code: exit_code,
+      output_dir: envelope.output_dir,
+      started_at: envelope.started_at,
+      completed_at: envelope.completed_at,
+      duration_ms: envelope.duration_ms,
+      usd_cost_total: envelope.usd_cost_total,
+      synthesis_skipped: Map.get(envelope, :synthesis_skipped, false),
+      tags: Map.get(envelope, :tags, %{}),
+      agents: agents,
+      usage_total: usage_total(Map.get(envelope, :usd_cost_by_model)),
+      latency_by_model: latency_by_model(agents, Map.get(envelope, :attempt_durations, %{}))
+    }
+  end
+
+  # Token counts summed across every model in the run, retries included, for a
+  # quick glance without walking `usd_cost_by_model`.
+  @spec usage_total(map() | nil) :: %{String.t() => non_neg_integer()}
+  def usage_total(usage_by_model) do
+    models = Map.values(usage_by_model || %{})
+    Map.new(@usage_keys, fn key -> {key, models |> Enum.map(&(&1[key] || 0)) |> Enum.sum()} end)
+  end
+
+  # Per model: wall-clock for each successful agent (retries and backoff
+  # included), how many agents failed or timed out, and `attempt_ms`, the
+  # subprocess time of every attempt, failed ones included. A model whose
+  # agents all timed out still shows up, so it is not mistaken for a fast one.
+  defp latency_by_model(agents, attempt_durations) do
+    agents
+    |> Enum.group_by(& &1.model)
+    |> Map.new(fn {model, model_agents} ->
+      ok_ms = for %{status: "ok", duration_ms: ms} <- model_agents, is_integer(ms), do: ms
+      timed_out = Enum.count(model_agents, &(&1.error_category == "timeout"))
+      failed = Enum.count(model_agents, &(&1.status != "ok")) - timed_out
+      attempt_ms = Enum.flat_map(model_agents, &Map.get(attempt_durations, &1.instance_id, []))
+
+      counts = %{failed: failed, timed_out: timed_out, attempt_ms: duration_stats(attempt_ms)}
+      {model, Map.merge(duration_stats(ok_ms), counts)}
+    end)
+  end
+
+  defp duration_stats([]), do: %{count: 0, min_ms: nil, mean_ms: nil, max_ms: nil}
+
+  defp duration_stats(durations) do
+    %{
+      count: length(durations),
+      min_ms: Enum.min(durations),
+      mean_ms: div(Enum.sum(durations), length(durations)),
+      max_ms: Enum.max(durations)
+    }
+  end
+
+  defp agent_summary(%{"name" => name, "id" => instance_id} = agent) do
+    metadata = agent["metadata"] || %{}
+    usage = metadata["usage"] || %{}
+
+    %{
+      name: name,
+      instance_id: instance_id,
+      status: metadata["status"],
+      provider: metadata["provider"],
+      model: metadata["model"],
+      attempts: metadata["attempts"],
+      duration_ms: metadata["duration_ms"],
+      truncated: metadata["truncated"] == true,
+      input_tokens: usage["input_tokens"],
+      output_tokens: usage["output_tokens"],
+      usd_cost: usage["usd_cost"],
+      error_category: get_in(metadata, ["error", "category"]),
+      attempt_errors: get_in(metadata, ["error", "attempt_errors"]) || []
+    }
+  end
+
+  defp envelope(nil), do: nil
+
+  defp envelope(output_dir) do
+    if File.exists?(Path.join(output_dir, ArtifactLayout.manifest_file())) do
+      input = contract_input(output_dir)
+
+      output_dir
+      |> RunStore.result_envelope()
+      |> Map.put(:synthesis_skipped, input["no_synthesis"] == true)
+      |> Map.put(:tags, input["tags"] || %{})
+      |> Map.put(:attempt_durations, attempt_durations(output_dir))
+    end
+  end
+
+  # Each `subprocess_finished` trace event carries one attempt's subprocess
+  # time; they are grouped by agent instance id.
+  defp attempt_durations(output_dir) do
+    path = Path.join(output_dir, TraceLog.events_file())
+
+    if File.regular?(path) do
+      path
+      |> File.stream!(:line, [])
+      |> Enum.reduce(%{}, fn line, acc ->
+        case Jason.decode(line) do
+          {:ok, %{"event" => "subprocess_finished", "instance_id" => id, "duration_ms" => ms}}
+          when is_integer(ms) ->
+            Map.update(acc, id, [ms], &[ms | &1])
+
+          _ ->
+            acc
+        end
+      end)
+    else
+      %{}
+    end
+  end
+
+  # `--no-synthesis` and `--tag` are recorded in the run contract; the manifest
+  # alone cannot tell a skipped synthesizer from a bench that never had one.
+  defp contract_input(output_dir) do
+    with {:ok, body} <- File.read(Path.join(output_dir, ArtifactLayout.contract_file())),
+         {:ok, %{"input" => %{} = input}} <- Jason.decode(body) do
+      input
+    else
+      _ -> %{}
+    end
+  end
+end
@@ -0,0 +1,40 @@
+defmodule Thinktank.CLI.SecretPaths do
+  @moduledoc false
+
+  # Filenames that usually hold credentials. Agents read whatever `--paths`
+  # points at and may quote it back to their provider, so these are flagged
+  # before launch. Named directories are checked one level deep (the usual
+  # `--paths .` case); deeper trees are left to the agents' own judgement.
+  @exact_names ~w(.env id_rsa id_dsa id_ecdsa id_ed25519 credentials .netrc .npmrc .pypirc)
+  @extensions ~w(.pem .key .keystore .jks .p12 .pfx)
+  @template_extensions ~w(.example .sample .template)
+
+  @spec matches([Path.t()]) :: [Path.t()]
+  def matches(paths) when is_list(paths) do
+    paths
+    |> Enum.flat_map(&candidates/1)
+    |> Enum.filter(&secret_name?(Path.basename(&1)))
+    |> Enum.uniq()
+  end
+
+  @spec warning([Path.t()]) :: String.t()
+  def warning(matches) do
+    "--paths includes files that commonly hold secrets: #{Enum.join(matches, ", ")} " <>
+      "(agents may send their contents to the provider; pass --refuse-secrets to stop instead)"
+  end
+
+  defp candidates(path) do
+    case File.ls(path) do
+      {:ok, entries} -> [path | Enum.map(entries, &Path.join(path, &1))]
+      {:error, _reason} -> [path]
+    end
+  end
+
+  defp secret_name?(name) do
+    cond do
+      Path.extname(name) in @template_extensions -> false
+      name in @exact_names or String.starts_with?(name, ".env.") -> true
+      true -> Path.extname(name) in @extensions
+    end
+  end
+end
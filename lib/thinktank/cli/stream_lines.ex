@@ -0,0 +1,52 @@
+defmodule Thinktank.CLI.StreamLines do
+  @moduledoc false
+
+  # `--stream` output arrives as raw port reads that split lines at arbitrary
+  # byte boundaries. Each agent's unfinished last line is held back until its
+  # newline arrives or the attempt ends, so every printed line is whole and
+  # carries exactly one `[agent]` prefix. Agents stream concurrently, so the
+  # buffers live in a small Agent keyed by instance id.
+
+  @spec start() :: pid()
+  def start do
+    {:ok, pid} = Agent.start_link(fn -> %{} end)
+    pid
+  end
+
+  # Stops the buffer process and returns any lines still held back, for a run
+  # that ended before every agent reported finishing.
+  @spec stop(pid() | nil) :: [String.t()]
+  def stop(nil), do: []
+
+  def stop(buffers) do
+    rest = Agent.get(buffers, &Map.values/1)
+    Agent.stop(buffers)
+    for {name, line} <- rest, line != "", do: prefix(name, line)
+  end
+
+  @spec lines(pid(), String.t(), map()) :: [String.t()]
+  def lines(buffers, "agent_output", %{"instance_id" => id, "agent_name" => name} = attrs) do
+    Agent.get_and_update(buffers, fn pending ->
+      {_name, held} = Map.get(pending, id, {name, ""})
+      [rest | complete] = (held <> attrs["chunk"]) |> String.split("\n") |> Enum.reverse()
+
+      lines = complete |> Enum.reverse() |> Enum.map(&prefix(name, &1))
+      {lines, Map.put(pending, id, {name, rest})}
+    end)
+  end
+
+  def lines(buffers, event, %{"instance_id" => id, "agent_name" => name})
+      when event in ["agent_retrying", "agent_finished"] do
+    Agent.get_and_update(buffers, fn pending ->
+      case Map.pop(pending, id, {name, ""}) do
+        {{_name, ""}, pending} -> {[], pending}
+        {{_name, rest}, pending} -> {[prefix(name, rest)], pending}
+      end
+    end)
+  end
+
+  def lines(_buffers, _event, _attrs), do: []
+
+  defp prefix(name, ""), do: "[#{name}]"
+  defp prefix(name, line), do: "[#{name}] #{line}"
+end
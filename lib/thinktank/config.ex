@@ -18,11 +18,7 @@ defmodule Thinktank.Config do
   def load(opts \\ []) do
     cwd = Keyword.get(opts, :cwd, File.cwd!())
 
-    trust_repo_config =
-      case Keyword.get(opts, :trust_repo_config) do
-        nil -> trust_repo_config?()
-        value -> value
-      end
+    trust_repo_config = trust_repo_config?(Keyword.get(opts, :trust_repo_config))
 
     user_path =
       Keyword.get(opts, :user_config_path, Path.join(user_config_dir(opts), "config.yml"))
@@ -59,11 +55,26 @@ defmodule Thinktank.Config do
     Path.join([home, ".config", "thinktank"])
   end
 
+  @spec trust_repo_config?(boolean() | nil) :: boolean()
+  def trust_repo_config?(nil) do
+    System.get_env("THINKTANK_TRUST_REPO_CONFIG") in ["1", "true", "TRUE", "yes", "YES"]
+  end
+
+  def trust_repo_config?(explicit), do: explicit
+
   @spec trust_repo_agent_config?() :: boolean()
   def trust_repo_agent_config? do
     System.get_env("THINKTANK_TRUST_REPO_AGENT_CONFIG") in ["1", "true", "TRUE", "yes", "YES"]
   end
 
+  @spec repo_agent_config_dir(Path.t()) :: Path.t() | nil
+  def repo_agent_config_dir(root) do
+    if trust_repo_agent_config?() do
+      dir = Path.join(root, "agent_config")
+      if File.dir?(dir), do: dir
+    end
+  end
+
   defp build(raw, sources) do
     agent_defaults = get_in(raw, ["defaults", "agent"])
 
@@ -193,7 +204,4 @@ defmodule Thinktank.Config do
     end)
   end
 
-  defp trust_repo_config? do
-    System.get_env("THINKTANK_TRUST_REPO_CONFIG") in ["1", "true", "TRUE", "yes", "YES"]
-  end
 end
@@ -4,7 +4,7 @@ defmodule Thinktank.Engine do
   """
 
   alias Thinktank.{AgentSpec, BenchSpec, Config, Error, RunContract, RunSession}
-  alias Thinktank.Engine.Preparation
+  alias Thinktank.Engine.{ModelPolicy, Preparation}
   alias Thinktank.Executor.Agentic
 
   @type run_result :: %{
@@ -44,7 +44,10 @@ defmodule Thinktank.Engine do
          {:ok, input} <- Preparation.normalize_input(bench, input),
          {:ok, agents} <- Preparation.resolve_agents(bench, config, input),
          {:ok, planner} <- Preparation.resolve_planner(bench, config),
-         {:ok, synthesizer} <- Preparation.resolve_synthesizer(bench, config) do
+         {:ok, synthesizer} <- Preparation.resolve_synthesizer(bench, config),
+         launched = launched_agents(input, planner, synthesizer, agents),
+         :ok <- ModelPolicy.check(launched),
+         :ok <- maybe_check_credentials(opts, config, launched) do
       output_dir = Keyword.get(opts, :output) || generate_output_dir(bench_id)
 
       contract = %RunContract{
@@ -99,5 +102,33 @@ defmodule Thinktank.Engine do
           {:ok, run_result()} | {:error, Error.t(), String.t() | nil}
   def run_resolved(%{} = resolved, opts \\ []), do: RunSession.execute(resolved, opts)
 
+  @spec preview_prompts(resolved_run()) ::
+          {:ok, [%{agent: String.t(), prompt: String.t()}]} | {:error, Error.t()}
+  def preview_prompts(%{bench: bench, agents: agents, contract: contract}) do
+    case Preparation.preview_execution(bench, agents, contract) do
+      {:ok, planned_agents, context} ->
+        {:ok,
+         Enum.map(planned_agents, fn agent ->
+           %{agent: agent.name, prompt: Agentic.render_prompt(agent, contract, context)}
+         end)}
+
+      {:error, reason} ->
+        {:error, normalize_error(reason)}
+    end
+  end
+
+  defp launched_agents(input, planner, synthesizer, agents) do
+    synthesizer = if input["no_synthesis"], do: nil, else: synthesizer
+    [planner, synthesizer | agents]
+  end
+
+  defp maybe_check_credentials(opts, config, launched) do
+    if Keyword.get(opts, :require_credentials, false) do
+      Preparation.check_credentials(launched, config)
+    else
+      :ok
+    end
+  end
+
   defp normalize_error(reason), do: Error.from_reason(reason)
 end
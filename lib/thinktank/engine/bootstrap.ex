@@ -34,11 +34,18 @@ defmodule Thinktank.Engine.Bootstrap do
   defp init_run(output_dir, contract, bench) do
     rescue_bootstrap_failure("init_run", bench, contract, fn ->
       RunStore.init_run(output_dir, contract, bench)
-      RunTracker.start(output_dir, %{"bench" => bench.id})
+      RunTracker.start(output_dir, tracker_attrs(contract, bench))
       :ok
     end)
   end
 
+  defp tracker_attrs(contract, bench) do
+    case Map.get(contract.input, "tags") do
+      tags when is_map(tags) and map_size(tags) > 0 -> %{"bench" => bench.id, "tags" => tags}
+      _ -> %{"bench" => bench.id}
+    end
+  end
+
   defp write_task_artifact(output_dir, input, bench, contract, opts) do
     rescue_bootstrap_failure("task_artifact", bench, contract, fn ->
       maybe_fail_post_init_bootstrap(output_dir, opts)
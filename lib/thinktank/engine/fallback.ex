@@ -0,0 +1,61 @@
+defmodule Thinktank.Engine.Fallback do
+  @moduledoc false
+
+  alias Thinktank.AgentSpec
+
+  # Agents that still fail after their own retries get one more run on their
+  # `fallback_model`. The fallback replaces the failed result in place, so
+  # status, coverage, and synthesis see it, while the failed primary is still
+  # recorded. A primary whose fallback succeeded is marked `recovered_by` so it
+  # leaves no failure record in a run that completed. Fallback agents carry no
+  # fallback of their own, so chains cannot loop.
+  @spec run([map()], ([AgentSpec.t()] -> [map()])) :: {[map()], [map()]}
+  def run(results, run_fun) when is_function(run_fun, 1) do
+    failed =
+      results
+      |> Enum.with_index()
+      |> Enum.filter(fn {result, _index} -> fallback?(result) end)
+
+    case failed do
+      [] ->
+        {results, results}
+
+      failed ->
+        fallbacks =
+          failed
+          |> Enum.map(fn {result, _index} -> fallback_agent(result.agent) end)
+          |> run_fun.()
+          |> Enum.zip(failed)
+          |> Map.new(fn {fallback, {primary, index}} ->
+            {index, Map.put(fallback, :fallback_for, primary.instance_id)}
+          end)
+
+        final = results |> Enum.with_index() |> Enum.map(&replace(&1, fallbacks))
+        recorded = results |> Enum.with_index() |> Enum.flat_map(&with_fallback(&1, fallbacks))
+        {final, recorded}
+    end
+  end
+
+  defp fallback?(%{status: :error, agent: %AgentSpec{fallback_model: model}}),
+    do: is_binary(model)
+
+  defp fallback?(_result), do: false
+
+  defp fallback_agent(%AgentSpec{} = agent),
+    do: %AgentSpec{agent | model: agent.fallback_model, fallback_model: nil}
+
+  defp replace({result, index}, fallbacks), do: Map.get(fallbacks, index, result)
+
+  defp with_fallback({result, index}, fallbacks) do
+    case Map.fetch(fallbacks, index) do
+      {:ok, %{status: :ok} = fallback} ->
+        [Map.put(result, :recovered_by, fallback.instance_id), fallback]
+
+      {:ok, fallback} ->
+        [result, fallback]
+
+      :error ->
+        [result]
+    end
+  end
+end
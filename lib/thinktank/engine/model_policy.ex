@@ -0,0 +1,77 @@
+defmodule Thinktank.Engine.ModelPolicy do
+  @moduledoc false
+
+  alias Thinktank.Error
+
+  # Operator policy from the environment, applied after bench expansion and
+  # config merging so neither `--agents` nor repo config can route around it.
+  # Entries are comma-separated model ids; a trailing `*` matches a prefix
+  # (`openai/*`). The deny list wins over the allow list.
+  @allow_env "THINKTANK_ALLOWED_MODELS"
+  @deny_env "THINKTANK_DENIED_MODELS"
+
+  @spec check([map() | nil]) :: :ok | {:error, Error.t()}
+  def check(agents) do
+    check(agents, env_list(@allow_env), env_list(@deny_env))
+  end
+
+  defp check(_agents, nil, nil), do: :ok
+
+  defp check(agents, allowed, denied) do
+    violations =
+      agents
+      |> Enum.reject(&is_nil/1)
+      |> Enum.flat_map(&agent_models/1)
+      |> Enum.flat_map(fn {agent, model} ->
+        case violation(model, allowed, denied) do
+          nil -> []
+          reason -> [%{agent: agent, model: model, reason: reason}]
+        end
+      end)
+
+    case violations do
+      [] ->
+        :ok
+
+      violations ->
+        {:error,
+         %Error{
+           code: :model_policy_violation,
+           message:
+             "model policy rejects: " <>
+               Enum.map_join(violations, ", ", &"#{&1.agent} (#{&1.model}, #{&1.reason})"),
+           details: %{violations: violations}
+         }}
+    end
+  end
+
+  defp agent_models(agent) do
+    [agent.model, Map.get(agent, :fallback_model)]
+    |> Enum.reject(&is_nil/1)
+    |> Enum.map(&{agent.name, &1})
+  end
+
+  defp violation(model, allowed, denied) do
+    cond do
+      denied && Enum.any?(denied, &matches?(&1, model)) -> "in #{@deny_env}"
+      allowed && not Enum.any?(allowed, &matches?(&1, model)) -> "not in #{@allow_env}"
+      true -> nil
+    end
+  end
+
+  defp matches?(pattern, model) do
+    if String.ends_with?(pattern, "*"),
+      do: String.starts_with?(model, String.trim_trailing(pattern, "*")),
+      else: pattern == model
+  end
+
+  defp env_list(name) do
+    entries =
+      (System.get_env(name) || "")
+      |> String.split(",")
+      |> Enum.map(&String.trim/1)
+      |> Enum.reject(&(&1 == ""))
+
+    if entries == [], do: nil, else: entries
+  end
+end
@@ -0,0 +1,49 @@
+defmodule Thinktank.Engine.OutputTransform do
+  @moduledoc false
+
+  alias Thinktank.{ArtifactLayout, RunStore}
+  alias Thinktank.Executor.Agentic
+
+  # Library callers may pass `output_transform: fn agent, output -> ... end` to
+  # `Thinktank.Engine.run/3` to rewrite each successful agent output before it
+  # is recorded and handed to the synthesizer. The untouched output always
+  # lands in an `agents/{instance_id}.raw.md` sidecar; a failed transform marks
+  # the agent failed and keeps the raw output as its result.
+  @spec run(Agentic.result(), Path.t(), keyword()) :: Agentic.result()
+  def run(%{status: :ok} = result, output_dir, opts) do
+    case Keyword.get(opts, :output_transform) do
+      nil -> result
+      transform -> transform_result(result, transform, output_dir)
+    end
+  end
+
+  def run(result, _output_dir, _opts), do: result
+
+  defp transform_result(result, transform, output_dir) do
+    RunStore.write_text_artifact(
+      output_dir,
+      "agent-raw-#{result.instance_id}",
+      ArtifactLayout.agent_raw_result_file(result.instance_id),
+      result.output
+    )
+
+    case safe_transform(transform, result) do
+      {:ok, output} when is_binary(output) ->
+        %{result | output: output}
+
+      other ->
+        %{result | status: :error, error: %{category: :output_transform, message: message(other)}}
+    end
+  end
+
+  defp safe_transform(transform, result) do
+    transform.(result.agent, result.output)
+  rescue
+    error -> {:error, Exception.message(error)}
+  end
+
+  defp message({:error, reason}) when is_binary(reason), do: "output transform failed: #{reason}"
+
+  defp message(other),
+    do: "output transform failed: expected {:ok, output}, got #{inspect(other)}"
+end
@@ -1,7 +1,8 @@
 defmodule Thinktank.Engine.Preparation do
   @moduledoc false
 
-  alias Thinktank.{ArtifactLayout, BenchSpec, Config, RunStore, TraceLog}
+  alias Thinktank.{ArtifactLayout, BenchSpec, Config, Error, ProviderSpec, RunStore, TraceLog}
+  alias Thinktank.Executor.ProviderEnv
   alias Thinktank.Review.{Context, Planner}
 
   @spec normalize_input(BenchSpec.t(), map()) :: {:ok, map()} | {:error, atom() | String.t()}
@@ -44,15 +45,7 @@ defmodule Thinktank.Engine.Preparation do
         planning = plan_review(agents, planner, contract, review_context, config, opts)
         planned_agents = Planner.apply_plan(planning.plan, agents)
         write_review_artifacts(output_dir, review_context, planning)
-
-        context = %{
-          "paths_hint" => render_paths_hint(contract.input),
-          "review_context" => render_json(review_context),
-          "review_plan" => render_json(planning.plan),
-          "synthesis_brief" => planning.plan["synthesis_brief"] || ""
-        }
-
-        {:ok, planned_agents, context}
+        {:ok, planned_agents, review_prompt_context(contract, review_context, planning.plan)}
 
       {:error, _reason} = error ->
         error
@@ -63,6 +56,23 @@ defmodule Thinktank.Engine.Preparation do
     {:ok, agents, %{"paths_hint" => render_paths_hint(contract.input)}}
   end
 
+  # Mirrors prepare_execution/7 without launching the planner or writing
+  # artifacts: review benches capture git context and keep every reviewer.
+  @spec preview_execution(BenchSpec.t(), [map()], map()) ::
+          {:ok, [map()], map()} | {:error, term()}
+  def preview_execution(%BenchSpec{kind: :review}, agents, contract) do
+    with {:ok, review_context} <- Context.capture(contract.workspace_root, contract.input) do
+      %{plan: plan} = Planner.manual(agents)
+
+      {:ok, Planner.apply_plan(plan, agents),
+       review_prompt_context(contract, review_context, plan)}
+    end
+  end
+
+  def preview_execution(_bench, agents, contract) do
+    {:ok, agents, %{"paths_hint" => render_paths_hint(contract.input)}}
+  end
+
   @spec resolve_agents(BenchSpec.t(), Config.t(), map()) :: {:ok, [map()]} | {:error, String.t()}
   def resolve_agents(%BenchSpec{agents: bench_agents}, %Config{agents: agents}, input) do
     names =
@@ -103,6 +113,45 @@ defmodule Thinktank.Engine.Preparation do
   def preparation_phase(%BenchSpec{kind: :review}, _planner), do: "preparing_review"
   def preparation_phase(_bench, _planner), do: "preparing_run"
 
+  # Offline preflight: every provider the run would launch must have a key in
+  # the environment. Keyless adapters such as ollama are skipped.
+  @spec check_credentials([map() | nil], Config.t()) :: :ok | {:error, Error.t()}
+  def check_credentials(agents, %Config{providers: providers}) do
+    missing =
+      agents
+      |> Enum.reject(&is_nil/1)
+      |> Enum.map(&Map.get(providers, &1.provider))
+      |> Enum.uniq()
+      |> Enum.filter(&missing_credentials?/1)
+
+    case missing do
+      [] ->
+        :ok
+
+      missing ->
+        {:error,
+         %Error{
+           code: :provider_credentials_missing,
+           message:
+             "provider credentials are unset: " <>
+               Enum.map_join(missing, ", ", &"#{&1.id} (#{credential_envs(&1)})"),
+           details: %{providers: Enum.map(missing, & &1.id)}
+         }}
+    end
+  end
+
+  defp missing_credentials?(%ProviderSpec{credential_env: nil}), do: false
+  defp missing_credentials?(%ProviderSpec{} = provider),
+    do: ProviderEnv.credentials(provider) == []
+
+  defp missing_credentials?(nil), do: false
+
+  defp credential_envs(provider) do
+    [provider.credential_env, provider.defaults["fallback_env"]]
+    |> Enum.filter(&(is_binary(&1) and &1 != ""))
+    |> Enum.join(" or ")
+  end
+
   @spec resolve_config(Config.t() | nil, keyword()) :: {:ok, Config.t()} | {:error, term()}
   def resolve_config(%Config{} = config, _opts), do: {:ok, config}
   def resolve_config(nil, opts), do: Config.load(opts)
@@ -120,6 +169,15 @@ defmodule Thinktank.Engine.Preparation do
 
   def render_paths_hint(_), do: "- none specified"
 
+  defp review_prompt_context(contract, review_context, plan) do
+    %{
+      "paths_hint" => render_paths_hint(contract.input),
+      "review_context" => render_json(review_context),
+      "review_plan" => render_json(plan),
+      "synthesis_brief" => plan["synthesis_brief"] || ""
+    }
+  end
+
   defp plan_review(agents, planner, contract, review_context, config, opts) do
     selected_agents = Map.get(contract.input, "agents", [])
 
@@ -130,6 +188,7 @@ defmodule Thinktank.Engine.Preparation do
         agent_config_dir: opts[:agent_config_dir],
         progress_callback: opts[:progress_callback],
         progress_phase: opts[:progress_phase],
+        prompt_transform: opts[:prompt_transform],
         runner: opts[:runner]
       )
     end
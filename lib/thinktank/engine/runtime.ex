@@ -131,7 +131,7 @@ defmodule Thinktank.Engine.Runtime do
     TraceLog
   }
 
-  alias Thinktank.Engine.Preparation
+  alias Thinktank.Engine.{Fallback, OutputTransform, Preparation}
   alias Thinktank.Executor.Agentic
   alias Thinktank.Research.Findings
   alias Thinktank.Review.{Coverage, DegradePolicy}
@@ -227,16 +227,30 @@ defmodule Thinktank.Engine.Runtime do
       total_agents: length(planned_agents)
     })
 
-    results =
-      Agentic.run(planned_agents, contract, context, config,
-        concurrency: bench.concurrency || length(planned_agents),
-        agent_config_dir: opts[:agent_config_dir],
-        progress_phase: Progress.phase_for_event("agents_started"),
-        progress_callback: opts[:progress_callback],
-        runner: opts[:runner]
-      )
+    agentic_opts = [
+      concurrency: bench.concurrency || length(planned_agents),
+      agent_config_dir: opts[:agent_config_dir],
+      progress_phase: Progress.phase_for_event("agents_started"),
+      progress_callback: opts[:progress_callback],
+      prompt_transform: opts[:prompt_transform],
+      allow_empty_output: opts[:allow_empty_output],
+      runner: opts[:runner]
+    ]
+
+    fallback_opts = Keyword.put(agentic_opts, :first_index, length(planned_agents) + 1)
+
+    launch = fn agents, launch_opts ->
+      agents
+      |> Agentic.run(contract, context, config, launch_opts)
+      |> Enum.map(&OutputTransform.run(&1, output_dir, opts))
+    end
 
-    Enum.each(results, &record_result(output_dir, &1))
+    {results, recorded} =
+      planned_agents
+      |> launch.(agentic_opts)
+      |> Fallback.run(&launch.(&1, fallback_opts))
+
+    Enum.each(recorded, &record_result(output_dir, &1, opts))
 
     review_degrade_policy =
       maybe_write_review_degrade_policy(
@@ -423,11 +437,14 @@ defmodule Thinktank.Engine.Runtime do
           agent_config_dir: opts[:agent_config_dir],
           progress_phase: Progress.phase_for_event("synthesis_started"),
           progress_callback: opts[:progress_callback],
+          prompt_transform: opts[:prompt_transform],
+          allow_empty_output: opts[:allow_empty_output],
           runner: opts[:runner]
         )
 
+      result = OutputTransform.run(result, output_dir, opts)
       handled_result = handle_synthesis_result(output_dir, bench, result)
-      record_result(output_dir, handled_result)
+      record_result(output_dir, handled_result, opts)
       handled_result
     end
   end
@@ -504,7 +521,7 @@ defmodule Thinktank.Engine.Runtime do
     end)
   end
 
-  defp record_result(output_dir, result) do
+  defp record_result(output_dir, result, opts) do
     output =
       case result.status do
         :ok ->
@@ -523,8 +540,55 @@ defmodule Thinktank.Engine.Runtime do
       completed_at: result.completed_at,
       duration_ms: result.duration_ms,
       usage: result.usage,
+      attempts: result[:attempts],
+      fallback_for: result[:fallback_for],
+      truncated: result[:truncated],
       error: result.error
     })
+
+    maybe_write_failure_record(output_dir, result, opts[:capture_requests] == true)
+  end
+
+  # The record never includes the agent's output, which already lives in
+  # agents/*.md. Request parameters are written only with `--capture-requests`.
+  defp maybe_write_failure_record(_output_dir, %{recovered_by: _}, _capture_requests?), do: :ok
+
+  defp maybe_write_failure_record(output_dir, %{status: :error} = result, capture_requests?) do
+    error = result.error || %{}
+
+    record = %{
+      "agent_name" => result.agent.name,
+      "instance_id" => result.instance_id,
+      "category" => error[:category],
+      "message" => error[:message],
+      "exit_code" => error[:exit_code],
+      "attempts" => error[:attempts],
+      "attempt_errors" => error[:attempt_errors] || [],
+      "started_at" => result.started_at,
+      "completed_at" => result.completed_at,
+      "duration_ms" => result.duration_ms
+    }
+
+    RunStore.write_json_artifact(
+      output_dir,
+      "failure-#{result.instance_id}",
+      ArtifactLayout.agent_failure_file(result.instance_id),
+      if(capture_requests?, do: Map.put(record, "request", request_params(result)), else: record)
+    )
+  end
+
+  defp maybe_write_failure_record(_output_dir, _result, _capture_requests?), do: :ok
+
+  defp request_params(%{agent: agent, instance_id: instance_id}) do
+    %{
+      "provider" => agent.provider,
+      "model" => agent.model,
+      "thinking_level" => agent.thinking_level,
+      "tools" => agent.tools,
+      "timeout_ms" => agent.timeout_ms,
+      "retries" => agent.retries,
+      "prompt_file" => ArtifactLayout.agent_prompt_file(instance_id)
+    }
   end
 
   defp derive_status(results, synthesis, review_degrade_policy) do
@@ -0,0 +1,59 @@
+defmodule Thinktank.EnvFile do
+  @moduledoc """
+  Loads `KEY=value` lines from a dotenv file into the process environment.
+
+  Variables that are already set always win, so a `.env` file can only fill
+  gaps. Blank lines, `#` comments, an optional `export ` prefix, and single-
+  or double-quoted values are supported; there is no variable interpolation.
+  """
+
+  @name_pattern ~r/^[A-Za-z_][A-Za-z0-9_]*$/
+
+  @spec load(Path.t()) :: {:ok, [String.t()]} | {:error, String.t()}
+  def load(path) do
+    case File.read(path) do
+      {:ok, body} ->
+        loaded = body |> parse() |> Enum.reject(fn {name, _value} -> System.get_env(name) end)
+        Enum.each(loaded, fn {name, value} -> System.put_env(name, value) end)
+        {:ok, loaded |> Enum.map(&elem(&1, 0)) |> Enum.uniq()}
+
+      {:error, reason} ->
+        {:error, "cannot read env file #{path}: #{:file.format_error(reason)}"}
+    end
+  end
+
+  @spec parse(String.t()) :: [{String.t(), String.t()}]
+  def parse(body) when is_binary(body) do
+    body
+    |> String.split(~r/\r?\n/)
+    |> Enum.flat_map(&parse_line(String.trim(&1)))
+  end
+
+  defp parse_line(""), do: []
+  defp parse_line("#" <> _comment), do: []
+  defp parse_line("export " <> line), do: parse_line(String.trim_leading(line))
+
+  defp parse_line(line) do
+    with [name, value] <- String.split(line, "=", parts: 2),
+         name = String.trim(name),
+         true <- Regex.match?(@name_pattern, name) do
+      [{name, parse_value(String.trim(value))}]
+    else
+      _ -> []
+    end
+  end
+
+  defp parse_value(<<mark, rest::binary>> = value) when mark in [?", ?'] do
+    case String.split(rest, <<mark>>, parts: 2) do
+      [inner, _trailing] -> inner
+      [_unterminated] -> value
+    end
+  end
+
+  defp parse_value(value) do
+    value
+    |> String.split(~r/\s+#/, parts: 2)
+    |> hd()
+    |> String.trim()
+  end
+end
@@ -1,8 +1,13 @@
 defmodule Thinktank.Error do
   @moduledoc """
   Structured error type for programmatic consumption.
+
+  Messages and details pass through `Thinktank.Redaction` so provider keys
+  echoed by a failing subprocess never surface in CLI or JSON output.
   """
 
+  alias Thinktank.Redaction
+
   @derive Jason.Encoder
   defstruct [:code, :message, :details]
 
@@ -38,19 +43,19 @@ defmodule Thinktank.Error do
   end
 
   def from_reason(reason) when is_binary(reason) do
-    %__MODULE__{code: :run_error, message: reason, details: %{}}
+    %__MODULE__{code: :run_error, message: Redaction.redact(reason), details: %{}}
   end
 
   def from_reason(%{category: cat} = map) do
     %__MODULE__{
       code: cat,
-      message: map[:message] || "agent error",
-      details: map
+      message: Redaction.redact(map[:message] || "agent error"),
+      details: Redaction.redact(map)
     }
   end
 
   def from_reason(other) do
-    %__MODULE__{code: :unknown, message: inspect(other), details: %{}}
+    %__MODULE__{code: :unknown, message: Redaction.redact(inspect(other)), details: %{}}
   end
 
   @spec from_contract(atom(), map()) :: t()
@@ -3,12 +3,32 @@ defmodule Thinktank.Executor.Agentic do
   Pi subprocess executor for tool-using agent runs.
   """
 
-  alias Thinktank.{AgentSpec, Config, Progress, RunContract, RunStore, Template, TraceLog}
-  alias Thinktank.Executor.OutputCollector
+  alias Thinktank.{
+    AgentSpec,
+    ArtifactLayout,
+    Config,
+    Progress,
+    Redaction,
+    RunContract,
+    RunStore,
+    RunTracker,
+    Template,
+    TraceLog
+  }
+
+  alias Thinktank.Executor.{
+    OutputCollector,
+    PromptTransform,
+    ProviderEnv,
+    RedactingSink,
+    RetryPolicy,
+    SessionUsage
+  }
 
   @allowed_tools MapSet.new(~w(read bash edit write grep find ls))
   @default_tools ["bash", "read", "grep", "find", "ls"]
   @default_timeout :timer.minutes(30)
+  @task_slack_ms 5_000
 
   @type result :: %{
           agent: AgentSpec.t(),
@@ -19,9 +39,33 @@ defmodule Thinktank.Executor.Agentic do
           completed_at: String.t() | nil,
           duration_ms: non_neg_integer() | nil,
           usage: map() | nil,
+          attempts: pos_integer() | nil,
+          truncated: boolean() | nil,
           error: map() | nil
         }
 
+  @doc """
+  Renders the exact prompt file contents Pi receives for `agent`.
+  """
+  @spec render_prompt(AgentSpec.t(), RunContract.t(), map()) :: String.t()
+  def render_prompt(%AgentSpec{} = agent, %RunContract{} = contract, context) do
+    rendered_prompt =
+      agent.task_prompt
+      |> Template.render(
+        contract.input
+        |> Map.merge(context)
+        |> Map.merge(stringify_keys(agent.metadata))
+        |> Map.merge(%{
+          "agent_name" => agent.name,
+          "bench_id" => contract.bench_id,
+          "workspace_root" => contract.workspace_root
+        })
+        |> stringify_keys()
+      )
+
+    "#{agent.system_prompt}\n\n#{rendered_prompt}"
+  end
+
   @spec run([AgentSpec.t()], RunContract.t(), map(), Config.t(), keyword()) :: [result()]
   def run(agents, contract, context, config, opts \\ [])
 
@@ -33,7 +77,7 @@ defmodule Thinktank.Executor.Agentic do
     progress_phase =
       Keyword.get(opts, :progress_phase, Progress.phase_for_event("agents_started"))
 
-    indexed_agents = Enum.with_index(agents, 1)
+    indexed_agents = Enum.with_index(agents, Keyword.get(opts, :first_index, 1))
 
     Enum.each(indexed_agents, fn {agent, index} ->
       instance_id = agent_instance_id(agent, index)
@@ -45,15 +89,6 @@ defmodule Thinktank.Executor.Agentic do
       })
     end)
 
-    timeout =
-      Enum.max(
-        Enum.map(agents, fn agent ->
-          attempts = max(agent.retries + 1, 1)
-          agent.timeout_ms * attempts + 250 * (attempts - 1)
-        end),
-        fn -> @default_timeout end
-      )
-
     concurrency =
       normalize_concurrency(Keyword.get(opts, :concurrency, length(agents)), length(agents))
 
@@ -63,7 +98,7 @@ defmodule Thinktank.Executor.Agentic do
         run_agent(agent, index, contract, context, config, runner, progress_phase, opts)
       end,
       max_concurrency: concurrency,
-      timeout: timeout + 5_000,
+      timeout: task_budget_ms(agents, opts),
       ordered: true,
       on_timeout: :kill_task
     )
@@ -74,7 +109,7 @@ defmodule Thinktank.Executor.Agentic do
 
       {{:exit, reason}, {agent, index}} when reason in [:timeout, {:timeout, nil}] ->
         instance_id = agent_instance_id(agent, index)
-        usage = session_usage(agent_home_path(contract, instance_id), agent.model)
+        usage = SessionUsage.read(agent_home_path(contract, instance_id), agent.model)
 
         RunStore.append_agent_note(
           contract.artifact_dir,
@@ -106,7 +141,7 @@ defmodule Thinktank.Executor.Agentic do
       {{:exit, reason}, {agent, index}} ->
         instance_id = agent_instance_id(agent, index)
         error = %{category: :crash, message: inspect(reason)}
-        usage = session_usage(agent_home_path(contract, instance_id), agent.model)
+        usage = SessionUsage.read(agent_home_path(contract, instance_id), agent.model)
 
         RunStore.append_agent_note(
           contract.artifact_dir,
@@ -175,28 +210,14 @@ defmodule Thinktank.Executor.Agentic do
     })
 
     try do
-      rendered_prompt =
-        agent.task_prompt
-        |> Template.render(
-          contract.input
-          |> Map.merge(context)
-          |> Map.merge(stringify_keys(agent.metadata))
-          |> Map.merge(%{
-            "agent_name" => agent.name,
-            "bench_id" => contract.bench_id,
-            "workspace_root" => contract.workspace_root
-          })
-          |> stringify_keys()
-        )
-
-      prompt = "#{agent.system_prompt}\n\n#{rendered_prompt}"
+      prompt = PromptTransform.run(render_prompt(agent, contract, context), agent, opts)
       prompt_file = write_prompt_file(contract, instance_id, prompt)
       provider = config.providers[agent.provider]
       agent_home = build_agent_home(contract, instance_id, opts[:agent_config_dir])
-      {cmd, args} = build_command(agent, prompt_file, tools, provider)
+      :ok = ProviderEnv.prepare_agent_home(agent_home, provider, agent.model)
+      {cmd, args} = build_command(agent, prompt_file, tools, provider, contract.input)
 
-      cmd_opts =
-        build_cmd_opts(agent, agent_home, instance_id, contract, provider)
+      cmd_opts = build_cmd_opts(agent, agent_home, instance_id, contract, opts)
 
       TraceLog.record_event(contract.artifact_dir, "prompt_written", %{
         "bench" => contract.bench_id,
@@ -214,24 +235,39 @@ defmodule Thinktank.Executor.Agentic do
       )
 
       max_attempts = max(agent.retries + 1, 1)
+      attempt_context = {contract.artifact_dir, trace_context, opts}
 
-      case attempt(max_attempts, contract.artifact_dir, trace_context, fn attempt_number ->
+      case attempt(agent, max_attempts, attempt_context, fn attempt_number ->
              run_once(
                runner,
                cmd,
                args,
-               cmd_opts,
+               attempt_cmd_opts(cmd_opts, provider, attempt_number),
                Map.merge(trace_context, %{
                  "attempt" => attempt_number,
-                 "max_attempts" => max_attempts
+                 "max_attempts" => max_attempts,
+                 "credential_slot" => ProviderEnv.credential_slot(provider, attempt_number)
                })
              )
+             |> RetryPolicy.check_output(opts)
            end) do
         {:ok, output, attempts_run} ->
-          usage = session_usage(agent_home, agent.model)
+          usage = SessionUsage.read(agent_home, agent.model)
+
+          truncated = SessionUsage.truncated?(agent_home)
 
           result =
-            timed_result(agent, instance_id, :ok, output, started_at, started_mono, nil, usage)
+            agent
+            |> timed_result(instance_id, :ok, output, started_at, started_mono, nil, usage)
+            |> Map.merge(%{attempts: attempts_run, truncated: truncated})
+
+          if truncated do
+            RunStore.append_agent_note(
+              contract.artifact_dir,
+              instance_id,
+              "a response hit the model's output token limit; the output is likely truncated"
+            )
+          end
 
           RunStore.append_agent_note(
             contract.artifact_dir,
@@ -264,7 +300,7 @@ defmodule Thinktank.Executor.Agentic do
           result
 
         {:error, %{output: output} = error, attempts_run} ->
-          usage = session_usage(agent_home, agent.model)
+          usage = SessionUsage.read(agent_home, agent.model)
 
           result =
             timed_result(
@@ -277,6 +313,7 @@ defmodule Thinktank.Executor.Agentic do
               Map.delete(error, :output),
               usage
             )
+            |> Map.put(:attempts, attempts_run)
 
           RunStore.append_agent_note(
             contract.artifact_dir,
@@ -311,19 +348,11 @@ defmodule Thinktank.Executor.Agentic do
       end
     rescue
       error ->
-        usage = session_usage(agent_home, agent.model)
+        usage = SessionUsage.read(agent_home, agent.model)
+        info = PromptTransform.error_info(error)
 
         result =
-          timed_result(
-            agent,
-            instance_id,
-            :error,
-            "",
-            started_at,
-            started_mono,
-            %{category: :crash, message: Exception.message(error)},
-            usage
-          )
+          timed_result(agent, instance_id, :error, "", started_at, started_mono, info, usage)
 
         RunStore.append_agent_note(
           contract.artifact_dir,
@@ -342,7 +371,7 @@ defmodule Thinktank.Executor.Agentic do
           "started_at" => started_at,
           "completed_at" => result.completed_at,
           "duration_ms" => result.duration_ms,
-          "error" => %{category: :crash, message: Exception.message(error)}
+          "error" => info
         })
 
         Progress.emit(opts, "agent_finished", %{
@@ -371,10 +400,16 @@ defmodule Thinktank.Executor.Agentic do
       "args" => args,
       "cwd" => cmd_opts[:cd],
       "timeout_ms" => cmd_opts[:timeout],
+      "credential_slot" => trace_context["credential_slot"],
       "env_keys" => cmd_opts |> Keyword.get(:env, []) |> Enum.map(&elem(&1, 0))
     })
 
-    case runner.(cmd, args, cmd_opts) do
+    # A provider may echo request headers back in an error, so the output is
+    # masked before it reaches the result, the agent files, or a retry record.
+    secrets = RunTracker.secrets(output_dir)
+    {output, status} = RedactingSink.run(cmd_opts, secrets, &runner.(cmd, args, &1))
+
+    case {Redaction.redact(output, secrets), status} do
       {output, 0} ->
         TraceLog.record_event(output_dir, "subprocess_finished", %{
           "bench" => trace_context["bench"],
@@ -401,7 +436,8 @@ defmodule Thinktank.Executor.Agentic do
           "output_bytes" => byte_size(output)
         })
 
-        {:error, %{category: :timeout, output: output}}
+        message = "pi timed out after #{cmd_opts[:timeout]} ms"
+        {:error, %{category: :timeout, message: message, output: output}}
 
       {output, exit_code} ->
         TraceLog.record_event(output_dir, "subprocess_finished", %{
@@ -415,7 +451,17 @@ defmodule Thinktank.Executor.Agentic do
           "output_bytes" => byte_size(output)
         })
 
-        {:error, %{category: :crash, exit_code: exit_code, output: output}}
+        message = crash_message(exit_code, output)
+        {:error, %{category: :crash, exit_code: exit_code, message: message, output: output}}
+    end
+  end
+
+  # The last output line usually names the failure (a provider error, a bad
+  # flag); it is already redacted and is capped so the history stays small.
+  defp crash_message(exit_code, output) do
+    case output |> String.trim() |> String.split("\n") |> List.last() |> String.trim() do
+      "" -> "pi exited with status #{exit_code}"
+      line -> "pi exited with status #{exit_code}: #{String.slice(line, 0, 200)}"
     end
   end
 
@@ -465,15 +511,21 @@ defmodule Thinktank.Executor.Agentic do
       completed_at: runtime.completed_at,
       duration_ms: runtime.duration_ms,
       usage: runtime.usage,
+      attempts: nil,
       error: runtime.error
     }
   end
 
-  defp attempt(max_attempts, output_dir, trace_context, fun) when max_attempts > 0 do
-    do_attempt(1, max_attempts, output_dir, trace_context, fun)
+  # The context is `{output_dir, trace_context, opts}`; `opts` carries the
+  # progress callback that hears about retries and an optional `:sleep` for
+  # the backoff wait.
+  defp attempt(agent, max_attempts, context, fun) when max_attempts > 0 do
+    do_attempt(agent, 1, max_attempts, context, fun, [])
   end
 
-  defp do_attempt(current, max_attempts, output_dir, trace_context, fun) do
+  defp do_attempt(agent, current, max_attempts, context, fun, history) do
+    {output_dir, trace_context, opts} = context
+
     TraceLog.record_event(output_dir, "attempt_started", %{
       "bench" => trace_context["bench"],
       "agent_name" => trace_context["agent_name"],
@@ -514,6 +566,17 @@ defmodule Thinktank.Executor.Agentic do
       {:error, error} ->
         trimmed_error = Map.delete(error, :output)
 
+        history = [
+          %{
+            attempt: current,
+            category: error[:category],
+            message: error[:message],
+            exit_code: error[:exit_code],
+            duration_ms: elapsed_ms(started_mono)
+          }
+          | history
+        ]
+
         TraceLog.record_event(output_dir, "attempt_finished", %{
           "bench" => trace_context["bench"],
           "agent_name" => trace_context["agent_name"],
@@ -532,8 +595,9 @@ defmodule Thinktank.Executor.Agentic do
           "attempt #{current}/#{max_attempts} failed with #{trimmed_error[:category]}"
         )
 
-        if current < max_attempts and retryable?(error) do
+        if current < max_attempts and RetryPolicy.retryable?(agent, error) do
           next_attempt = current + 1
+          delay_ms = RetryPolicy.delay_ms(agent, current)
 
           TraceLog.record_event(output_dir, "attempt_retry_scheduled", %{
             "bench" => trace_context["bench"],
@@ -541,29 +605,42 @@ defmodule Thinktank.Executor.Agentic do
             "instance_id" => trace_context["instance_id"],
             "attempt" => current,
             "next_attempt" => next_attempt,
-            "delay_ms" => 250,
+            "delay_ms" => delay_ms,
             "error" => trimmed_error
           })
 
           RunStore.append_agent_note(
             output_dir,
             trace_context["instance_id"],
-            "retrying after attempt #{current}; next attempt #{next_attempt} in 250 ms"
+            "retrying after attempt #{current}; next attempt #{next_attempt} in #{delay_ms} ms"
           )
 
-          Process.sleep(250)
-          do_attempt(next_attempt, max_attempts, output_dir, trace_context, fun)
+          Progress.emit(opts, "agent_retrying", %{
+            output_dir: output_dir,
+            agent_name: trace_context["agent_name"],
+            instance_id: trace_context["instance_id"],
+            attempt: next_attempt,
+            max_attempts: max_attempts,
+            delay_ms: delay_ms,
+            status: "retrying"
+          })
+
+          # The retry restarts from scratch; mark where in the raw stream it begins.
+          RunStore.append_agent_output(output_dir, trace_context["instance_id"], """
+
+          --- attempt #{next_attempt}: restarting; output above was discarded ---
+          """)
+
+          Keyword.get(opts, :sleep, &Process.sleep/1).(delay_ms)
+          do_attempt(agent, next_attempt, max_attempts, context, fun, history)
         else
-          {:error, error, current}
+          exhausted = %{attempts: current, attempt_errors: Enum.reverse(history)}
+          {:error, Map.merge(error, exhausted), current}
         end
     end
   end
 
-  defp retryable?(%{category: :timeout}), do: false
-  defp retryable?(%{category: :crash}), do: true
-  defp retryable?(_), do: false
-
-  defp build_command(agent, prompt_file, tools, provider) do
+  defp build_command(agent, prompt_file, tools, provider, input) do
     {"sh",
      [
        "-c",
@@ -581,50 +658,41 @@ defmodule Thinktank.Executor.Agentic do
        Enum.join(tools, ","),
        "-p",
        "@#{prompt_file}"
+       | attachment_args(agent, input)
      ]}
   end
 
-  defp build_cmd_opts(agent, agent_home, instance_id, contract, provider) do
-    provider_env = provider_env(provider)
+  # Pi sends `@file` image arguments as image parts; text-only agents skip them.
+  defp attachment_args(%AgentSpec{vision: true}, %{"attachments" => paths}) when is_list(paths),
+    do: Enum.map(paths, &"@#{&1}")
+
+  defp attachment_args(_agent, _input), do: []
+
+  defp build_cmd_opts(agent, agent_home, instance_id, contract, opts) do
+    output_sink = fn chunk ->
+      RunStore.append_agent_output(contract.artifact_dir, instance_id, chunk)
+
+      Progress.emit(opts, "agent_output", %{
+        output_dir: contract.artifact_dir,
+        agent_name: agent.name,
+        instance_id: instance_id,
+        chunk: chunk
+      })
+    end
 
     [
       stderr_to_stdout: true,
       timeout: agent.timeout_ms,
-      output_sink: &RunStore.append_agent_output(contract.artifact_dir, instance_id, &1),
-      env:
-        [
-          {"PI_CODING_AGENT_DIR", agent_home}
-        ] ++
-          provider_env,
+      output_sink: output_sink,
+      env: [{"PI_CODING_AGENT_DIR", agent_home}],
       cd: contract.workspace_root
     ]
   end
 
-  defp provider_env(%{adapter: :openrouter} = provider) do
-    fallback_env = provider.defaults["fallback_env"]
-
-    key =
-      non_empty_env(provider.credential_env) ||
-        if(is_binary(fallback_env) and fallback_env != "", do: non_empty_env(fallback_env))
-
-    if is_binary(key) and key != "" do
-      [{"OPENROUTER_API_KEY", key}]
-    else
-      []
-    end
+  defp attempt_cmd_opts(cmd_opts, provider, attempt_number) do
+    Keyword.update!(cmd_opts, :env, &(&1 ++ ProviderEnv.env(provider, attempt_number)))
   end
 
-  defp provider_env(_), do: []
-
-  defp non_empty_env(name) when is_binary(name) and name != "" do
-    case System.get_env(name) do
-      value when is_binary(value) and value != "" -> value
-      _ -> nil
-    end
-  end
-
-  defp non_empty_env(_), do: nil
-
   defp tool_list(%AgentSpec{tools: tools}) when is_list(tools) and tools != [] do
     sanitize_tools(tools)
   end
@@ -638,9 +706,8 @@ defmodule Thinktank.Executor.Agentic do
   end
 
   defp write_prompt_file(contract, instance_id, prompt) do
-    dir = Path.join(contract.artifact_dir, "prompts")
-    File.mkdir_p!(dir)
-    path = Path.join(dir, "#{instance_id}.md")
+    path = Path.join(contract.artifact_dir, ArtifactLayout.agent_prompt_file(instance_id))
+    File.mkdir_p!(Path.dirname(path))
     File.write!(path, prompt)
     path
   end
@@ -736,67 +803,6 @@ defmodule Thinktank.Executor.Agentic do
 
   defp runner_name(_), do: "custom"
 
-  defp session_usage(agent_home, model) do
-    agent_home
-    |> session_files()
-    |> Enum.flat_map(&assistant_usages_from_session/1)
-    |> aggregate_session_usage(model)
-  end
-
-  defp session_files(agent_home) do
-    Path.wildcard(Path.join([agent_home, "sessions", "**", "*.jsonl"]))
-  end
-
-  defp assistant_usages_from_session(path) do
-    path
-    |> File.stream!(:line, [])
-    |> Enum.flat_map(fn line ->
-      case Jason.decode(line) do
-        {:ok, %{"type" => "message", "message" => %{"role" => "assistant", "usage" => usage}}} ->
-          [usage]
-
-        _ ->
-          []
-      end
-    end)
-  rescue
-    _ -> []
-  end
-
-  defp aggregate_session_usage([], _model), do: nil
-
-  defp aggregate_session_usage(usages, model) do
-    aggregate =
-      Enum.reduce(
-        usages,
-        %{"input" => 0, "output" => 0, "cacheRead" => 0, "cacheWrite" => 0},
-        fn usage, acc ->
-          %{
-            "input" => acc["input"] + usage_value(usage, "input"),
-            "output" => acc["output"] + usage_value(usage, "output"),
-            "cacheRead" => acc["cacheRead"] + usage_value(usage, "cacheRead"),
-            "cacheWrite" => acc["cacheWrite"] + usage_value(usage, "cacheWrite")
-          }
-        end
-      )
-
-    total =
-      aggregate["input"] +
-        aggregate["output"] +
-        aggregate["cacheRead"] +
-        aggregate["cacheWrite"]
-
-    Thinktank.Pricing.normalize_usage(model, Map.put(aggregate, "totalTokens", total))
-  end
-
-  defp usage_value(usage, key) do
-    case Map.get(usage, key) do
-      value when is_integer(value) and value >= 0 -> value
-      value when is_float(value) and value >= 0 -> trunc(value)
-      _ -> 0
-    end
-  end
-
   defp relative_artifact_path(path, output_dir) do
     Path.relative_to(path, output_dir)
   end
@@ -806,6 +812,20 @@ defmodule Thinktank.Executor.Agentic do
     |> Base.encode16(case: :lower)
   end
 
+  # The outer task budget covers every attempt's timeout and every retry wait,
+  # plus slack (`:task_slack_ms`) for subprocess startup and teardown.
+  @doc false
+  @spec task_budget_ms([AgentSpec.t()], keyword()) :: pos_integer()
+  def task_budget_ms(agents, opts \\ []) do
+    agents
+    |> Enum.map(fn agent ->
+      attempts = max(agent.retries + 1, 1)
+      agent.timeout_ms * attempts + RetryPolicy.total_delay_ms(agent, attempts)
+    end)
+    |> Enum.max(fn -> @default_timeout end)
+    |> Kernel.+(Keyword.get(opts, :task_slack_ms, @task_slack_ms))
+  end
+
   @doc false
   def default_runner do
     if disable_muontrap?() or not muontrap_available?(), do: &system_cmd/3, else: &muontrap_cmd/3
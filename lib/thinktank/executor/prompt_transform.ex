@@ -0,0 +1,33 @@
+defmodule Thinktank.Executor.PromptTransform do
+  @moduledoc false
+
+  alias Thinktank.AgentSpec
+
+  # Library callers may pass `prompt_transform: fn agent, prompt -> ... end` to
+  # `Thinktank.Engine.run/3` to rewrite each rendered prompt right before it is
+  # written and launched. A failed transform aborts only that agent.
+  defmodule Error do
+    @moduledoc false
+    defexception [:message]
+  end
+
+  @spec run(String.t(), AgentSpec.t(), keyword()) :: String.t()
+  def run(prompt, agent, opts) do
+    case Keyword.get(opts, :prompt_transform) do
+      nil -> prompt
+      transform -> transform |> apply([agent, prompt]) |> unwrap()
+    end
+  end
+
+  @spec error_info(Exception.t()) :: map()
+  def error_info(%Error{message: message}), do: %{category: :prompt_transform, message: message}
+  def error_info(error), do: %{category: :crash, message: Exception.message(error)}
+
+  defp unwrap({:ok, prompt}) when is_binary(prompt), do: prompt
+
+  defp unwrap({:error, reason}) when is_binary(reason),
+    do: raise(Error, "prompt transform failed: #{reason}")
+
+  defp unwrap(other),
+    do: raise(Error, "prompt transform failed: expected {:ok, prompt}, got #{inspect(other)}")
+end
@@ -0,0 +1,136 @@
+defmodule Thinktank.Executor.ProviderEnv do
+  @moduledoc """
+  Resolves provider credentials into the environment handed to Pi.
+
+  Each adapter maps to the native Pi provider of the same name and the key
+  variable Pi reads for it, so a direct Anthropic, Google, or OpenAI key is
+  used without routing through OpenRouter. Keyless local adapters such as
+  `ollama` have no key at all; instead their OpenAI-compatible endpoint is
+  registered as a Pi custom provider in the agent's isolated `models.json`.
+  A keyed provider with `defaults.endpoint` overrides the native provider's
+  `baseUrl` there, so two providers on the same adapter can reach different
+  gateways with their own credential envs.
+
+  A credential env var may hold a comma-separated list of keys. Each attempt
+  uses the next key in the list, so a retry after a failed attempt lands on a
+  fresh key while single-key configurations behave exactly as before. The
+  rotation is per attempt, not per rate-limit: the launcher never parses
+  provider output, so every retryable failure moves to the next key.
+  """
+
+  alias Thinktank.ProviderSpec
+
+  @pi_credential_env %{
+    anthropic: "ANTHROPIC_API_KEY",
+    google: "GEMINI_API_KEY",
+    openai: "OPENAI_API_KEY",
+    openrouter: "OPENROUTER_API_KEY"
+  }
+
+  @default_ollama_endpoint "http://localhost:11434/v1"
+
+  @spec credentials(ProviderSpec.t() | nil) :: [String.t()]
+  def credentials(%ProviderSpec{} = provider) do
+    fallback_env = provider.defaults["fallback_env"]
+
+    (non_empty_env(provider.credential_env) || non_empty_env(fallback_env))
+    |> split_keys()
+  end
+
+  def credentials(_provider), do: []
+
+  @spec env(ProviderSpec.t() | nil, pos_integer()) :: [{String.t(), String.t()}]
+  def env(provider, attempt \\ 1)
+
+  def env(%ProviderSpec{adapter: adapter} = provider, attempt)
+      when is_map_key(@pi_credential_env, adapter) do
+    case credential_for_attempt(provider, attempt) do
+      nil -> []
+      key -> [{Map.fetch!(@pi_credential_env, adapter), key}]
+    end
+  end
+
+  def env(_provider, _attempt), do: []
+
+  @spec credential_slot(ProviderSpec.t() | nil, pos_integer()) :: non_neg_integer() | nil
+  def credential_slot(provider, attempt) when is_integer(attempt) and attempt > 0 do
+    case credentials(provider) do
+      [] -> nil
+      keys -> rem(attempt - 1, length(keys))
+    end
+  end
+
+  @spec prepare_agent_home(Path.t(), ProviderSpec.t() | nil, String.t()) :: :ok
+  def prepare_agent_home(agent_home, %ProviderSpec{adapter: :ollama} = provider, model) do
+    put_models_provider(agent_home, "ollama", %{
+      "baseUrl" => provider.defaults["endpoint"] || @default_ollama_endpoint,
+      "api" => "openai-completions",
+      "apiKey" => "ollama",
+      "models" => [
+        %{
+          "id" => model,
+          "name" => model,
+          "reasoning" => false,
+          "input" => ["text"],
+          "cost" => %{"input" => 0, "output" => 0, "cacheRead" => 0, "cacheWrite" => 0}
+        }
+      ]
+    })
+  end
+
+  def prepare_agent_home(
+        agent_home,
+        %ProviderSpec{adapter: adapter, defaults: %{"endpoint" => endpoint}},
+        _model
+      )
+      when is_map_key(@pi_credential_env, adapter) and is_binary(endpoint) and endpoint != "" do
+    put_models_provider(agent_home, Atom.to_string(adapter), %{"baseUrl" => endpoint})
+  end
+
+  def prepare_agent_home(_agent_home, _provider, _model), do: :ok
+
+  defp put_models_provider(agent_home, name, entry) do
+    path = Path.join(agent_home, "models.json")
+
+    models =
+      path
+      |> read_models()
+      |> Map.update("providers", %{name => entry}, &Map.put(&1, name, entry))
+
+    File.write!(path, Jason.encode!(models, pretty: true))
+  end
+
+  defp read_models(path) do
+    with {:ok, body} <- File.read(path),
+         {:ok, %{} = decoded} <- Jason.decode(body) do
+      decoded
+    else
+      _ -> %{}
+    end
+  end
+
+  defp credential_for_attempt(provider, attempt) do
+    case credential_slot(provider, attempt) do
+      nil -> nil
+      slot -> provider |> credentials() |> Enum.at(slot)
+    end
+  end
+
+  defp split_keys(nil), do: []
+
+  defp split_keys(value) do
+    value
+    |> String.split(",")
+    |> Enum.map(&String.trim/1)
+    |> Enum.reject(&(&1 == ""))
+  end
+
+  defp non_empty_env(name) when is_binary(name) and name != "" do
+    case System.get_env(name) do
+      value when is_binary(value) and value != "" -> value
+      _ -> nil
+    end
+  end
+
+  defp non_empty_env(_), do: nil
+end
@@ -0,0 +1,48 @@
+defmodule Thinktank.Executor.RedactingSink do
+  @moduledoc false
+
+  alias Thinktank.Redaction
+
+  # Port reads split output at arbitrary bytes, so a credential echoed by the
+  # provider can straddle two chunks and slip past a per-chunk redaction. The
+  # sink is redacted a whole line at a time instead: the unfinished last line
+  # is held back until its newline arrives or the subprocess exits.
+
+  @spec run(keyword(), [String.t()], (keyword() -> result)) :: result when result: term()
+  def run(cmd_opts, secrets, fun) do
+    case Keyword.get(cmd_opts, :output_sink) do
+      nil ->
+        fun.(cmd_opts)
+
+      emit ->
+        {:ok, pending} = Agent.start_link(fn -> "" end)
+
+        sink = fn chunk ->
+          pending
+          |> Agent.get_and_update(&split_complete_lines(&1 <> chunk))
+          |> emit_redacted(emit, secrets)
+        end
+
+        try do
+          fun.(Keyword.put(cmd_opts, :output_sink, sink))
+        after
+          pending |> Agent.get_and_update(&{&1, ""}) |> emit_redacted(emit, secrets)
+          Agent.stop(pending)
+        end
+    end
+  end
+
+  defp split_complete_lines(text) do
+    case :binary.matches(text, "\n") do
+      [] ->
+        {"", text}
+
+      matches ->
+        {at, 1} = List.last(matches)
+        {binary_part(text, 0, at + 1), binary_part(text, at + 1, byte_size(text) - at - 1)}
+    end
+  end
+
+  defp emit_redacted("", _emit, _secrets), do: :ok
+  defp emit_redacted(text, emit, secrets), do: emit.(Redaction.redact(text, secrets))
+end
@@ -0,0 +1,44 @@
+defmodule Thinktank.Executor.RetryPolicy do
+  @moduledoc false
+
+  alias Thinktank.AgentSpec
+
+  # Pure retry decisions for the executor loop. The agent spec carries the
+  # policy: `retry_on` categories, `retry_delay_ms` base, `retry_multiplier`
+  # growth, and a `retry_max_delay_ms` ceiling.
+
+  @spec retryable?(AgentSpec.t(), map()) :: boolean()
+  def retryable?(%AgentSpec{retry_on: retry_on}, error), do: error[:category] in retry_on
+
+  # A clean exit with blank output is a refusal or provider glitch, not a
+  # result: it fails the attempt so the retry budget applies, unless the
+  # caller passes `allow_empty_output: true`.
+  @spec check_output({:ok, String.t()} | {:error, map()}, keyword()) ::
+          {:ok, String.t()} | {:error, map()}
+  def check_output({:ok, output} = result, opts) do
+    if String.trim(output) == "" and not Keyword.get(opts, :allow_empty_output, false) do
+      {:error, %{category: :empty_output, message: "agent produced no output", output: output}}
+    else
+      result
+    end
+  end
+
+  def check_output(result, _opts), do: result
+
+  # Waits grow as base * multiplier^(attempt - 1), capped at the agent ceiling.
+  @spec delay_ms(AgentSpec.t(), pos_integer()) :: non_neg_integer()
+  def delay_ms(%AgentSpec{} = agent, attempt) when is_integer(attempt) and attempt > 0 do
+    (agent.retry_delay_ms * :math.pow(agent.retry_multiplier, attempt - 1))
+    |> round()
+    |> min(agent.retry_max_delay_ms)
+  end
+
+  # Sum of every wait between `attempts` attempts, used to size the outer task
+  # timeout so backoff never eats into the last attempt's budget.
+  @spec total_delay_ms(AgentSpec.t(), non_neg_integer()) :: non_neg_integer()
+  def total_delay_ms(_agent, attempts) when attempts < 2, do: 0
+
+  def total_delay_ms(%AgentSpec{} = agent, attempts) do
+    Enum.reduce(1..(attempts - 1), 0, &(&2 + delay_ms(agent, &1)))
+  end
+end
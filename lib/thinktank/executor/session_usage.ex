@@ -0,0 +1,83 @@
+defmodule Thinktank.Executor.SessionUsage do
+  @moduledoc false
+
+  # Reads the session JSONL Pi writes under each agent's isolated home. Every
+  # attempt leaves its own session file, so usage sums across retries.
+
+  @spec read(Path.t(), String.t() | nil) :: map() | nil
+  def read(agent_home, model) do
+    agent_home
+    |> assistant_messages()
+    |> Enum.flat_map(fn
+      %{"usage" => %{} = usage} -> [usage]
+      _message -> []
+    end)
+    |> aggregate(model)
+  end
+
+  # Pi records the provider's stop reason on each assistant message; `length`
+  # means the reply ran into the model's output token limit.
+  @spec truncated?(Path.t()) :: boolean()
+  def truncated?(agent_home) do
+    agent_home
+    |> assistant_messages()
+    |> Enum.any?(&(&1["stopReason"] == "length"))
+  end
+
+  defp assistant_messages(agent_home) do
+    [agent_home, "sessions", "**", "*.jsonl"]
+    |> Path.join()
+    |> Path.wildcard()
+    |> Enum.flat_map(&assistant_messages_from_session/1)
+  end
+
+  defp assistant_messages_from_session(path) do
+    path
+    |> File.stream!(:line, [])
+    |> Enum.flat_map(fn line ->
+      case Jason.decode(line) do
+        {:ok, %{"type" => "message", "message" => %{"role" => "assistant"} = message}} ->
+          [message]
+
+        _ ->
+          []
+      end
+    end)
+  rescue
+    _ -> []
+  end
+
+  defp aggregate([], _model), do: nil
+
+  defp aggregate(usages, model) do
+    aggregate =
+      Enum.reduce(
+        usages,
+        %{"input" => 0, "output" => 0, "cacheRead" => 0, "cacheWrite" => 0},
+        fn usage, acc ->
+          %{
+            "input" => acc["input"] + usage_value(usage, "input"),
+            "output" => acc["output"] + usage_value(usage, "output"),
+            "cacheRead" => acc["cacheRead"] + usage_value(usage, "cacheRead"),
+            "cacheWrite" => acc["cacheWrite"] + usage_value(usage, "cacheWrite")
+          }
+        end
+      )
+
+    total =
+      aggregate["input"] +
+        aggregate["output"] +
+        aggregate["cacheRead"] +
+        aggregate["cacheWrite"]
+
+    Thinktank.Pricing.normalize_usage(model, Map.put(aggregate, "totalTokens", total))
+  end
+
+  defp usage_value(usage, key) do
+    case Map.get(usage, key) do
+      value when is_integer(value) and value >= 0 -> value
+      value when is_float(value) and value >= 0 -> trunc(value)
+      _ -> 0
+    end
+  end
+end
@@ -1,6 +1,9 @@
 defmodule Thinktank.ProgressReporter do
   @moduledoc """
   Emits newline-delimited JSON progress events to stderr for `--json` runs.
+
+  With `stream: true`, raw agent output chunks are forwarded as
+  `agent_output` events as they arrive.
   """
 
   alias Thinktank.Progress
@@ -23,6 +26,7 @@ defmodule Thinktank.ProgressReporter do
       completed_agents: 0,
       failed_agents: 0,
       heartbeat_ms: heartbeat_ms(Keyword.get(opts, :heartbeat_ms)),
+      stream: Keyword.get(opts, :stream, false),
       emit: Keyword.fetch!(opts, :emit)
     }
 
@@ -89,6 +93,11 @@ defmodule Thinktank.ProgressReporter do
     end
   end
 
+  defp handle_event(%{stream: stream} = state, "agent_output", attrs) do
+    if stream, do: emit(state, "agent_output", attrs)
+    state
+  end
+
   defp handle_event(state, event, attrs) do
     phase = attrs["phase"] || Progress.phase_for_event(event)
 
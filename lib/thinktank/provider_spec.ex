@@ -4,23 +4,29 @@ defmodule Thinktank.ProviderSpec do
   """
 
   @valid_adapters %{
+    "anthropic" => :anthropic,
+    "google" => :google,
+    "ollama" => :ollama,
+    "openai" => :openai,
     "openrouter" => :openrouter
   }
 
+  @keyless_adapters [:ollama]
+
   @enforce_keys [:id, :adapter, :credential_env]
   defstruct [:id, :adapter, :credential_env, defaults: %{}]
 
   @type t :: %__MODULE__{
           id: String.t(),
           adapter: atom(),
-          credential_env: String.t(),
+          credential_env: String.t() | nil,
           defaults: map()
         }
 
   @spec from_pair(String.t(), map()) :: {:ok, t()} | {:error, String.t()}
   def from_pair(id, %{} = raw) when is_binary(id) do
     with {:ok, adapter} <- parse_adapter(raw["adapter"]),
-         {:ok, credential_env} <- parse_credential_env(raw["credential_env"]) do
+         {:ok, credential_env} <- parse_credential_env(raw["credential_env"], adapter) do
       {:ok,
        %__MODULE__{
          id: id,
@@ -49,6 +55,7 @@ defmodule Thinktank.ProviderSpec do
 
   defp parse_adapter(_), do: {:error, "provider adapter is required"}
 
-  defp parse_credential_env(env) when is_binary(env) and env != "", do: {:ok, env}
-  defp parse_credential_env(_), do: {:error, "provider credential_env is required"}
+  defp parse_credential_env(env, _adapter) when is_binary(env) and env != "", do: {:ok, env}
+  defp parse_credential_env(nil, adapter) when adapter in @keyless_adapters, do: {:ok, nil}
+  defp parse_credential_env(_, _adapter), do: {:error, "provider credential_env is required"}
 end
@@ -0,0 +1,98 @@
+defmodule Thinktank.Redaction do
+  @moduledoc """
+  Masks provider credentials before text reaches traces, logs, agent output, or
+  errors.
+
+  Two passes run over every string: known key shapes (`sk-...`, bearer tokens)
+  and the literal values of credential-looking environment variables, so a
+  provider echoing its own Authorization header cannot leak the key. The
+  environment is scanned at application start and again when a run starts
+  (see `Thinktank.RunTracker.start/2`), and the list is cached for `redact/1`.
+  """
+
+  @mask "***"
+  @min_secret_length 8
+  @secrets_key {__MODULE__, :secrets}
+
+  @spec redact(term()) :: term()
+  def redact(value), do: redact(value, secrets())
+
+  @spec redact(term(), [String.t()]) :: term()
+  def redact(text, secrets) when is_binary(text) do
+    secrets
+    |> Enum.reduce(text, &String.replace(&2, &1, @mask))
+    |> mask_key_shapes()
+  end
+
+  # Structs such as a nested `%Thinktank.Error{}` keep their type; only their
+  # field values are masked.
+  def redact(%module{} = value, secrets) do
+    struct!(module, value |> Map.from_struct() |> redact(secrets))
+  end
+
+  def redact(%{} = value, secrets) do
+    Map.new(value, fn {key, entry} -> {key, redact(entry, secrets)} end)
+  end
+
+  def redact(list, secrets) when is_list(list), do: Enum.map(list, &redact(&1, secrets))
+  def redact(value, _secrets), do: value
+
+  @doc """
+  Primary `:logger` filter that masks credentials in every log message.
+  """
+  @spec logger_filter(:logger.log_event(), term()) :: :logger.filter_return()
+  def logger_filter(%{msg: msg} = event, _extra) do
+    %{event | msg: redact_log_message(msg)}
+  rescue
+    _ -> event
+  end
+
+  @doc """
+  The cached credential values, scanning the environment on first use.
+  """
+  @spec secrets() :: [String.t()]
+  def secrets do
+    case :persistent_term.get(@secrets_key, nil) do
+      nil -> refresh_secrets()
+      secrets -> secrets
+    end
+  end
+
+  @doc """
+  Rescans the environment, for example after a `.env` file is loaded, and
+  caches the result.
+  """
+  @spec refresh_secrets() :: [String.t()]
+  def refresh_secrets do
+    secrets = env_secrets()
+    :persistent_term.put(@secrets_key, secrets)
+    secrets
+  end
+
+  defp env_secrets do
+    System.get_env()
+    |> Enum.filter(fn {name, value} ->
+      String.match?(name, ~r/(API_KEY|TOKEN|SECRET)$/) and
+        String.length(value) >= @min_secret_length
+    end)
+    |> Enum.flat_map(fn {_name, value} -> String.split(value, ",") end)
+    |> Enum.map(&String.trim/1)
+    |> Enum.filter(&(String.length(&1) >= @min_secret_length))
+    |> Enum.uniq()
+    |> Enum.sort_by(&String.length/1, :desc)
+  end
+
+  defp redact_log_message({:string, chardata}),
+    do: {:string, redact(IO.chardata_to_string(chardata))}
+
+  defp redact_log_message({:report, report}), do: {:report, redact(report)}
+
+  defp redact_log_message({format, args}) when is_list(args),
+    do: {:string, format |> :io_lib.format(args) |> IO.chardata_to_string() |> redact()}
+
+  defp mask_key_shapes(text) do
+    text
+    |> String.replace(~r/\b(Bearer)\s+[A-Za-z0-9._~+\/=\-]{8,}/i, "\\1 " <> @mask)
+    |> String.replace(~r/\bsk-[A-Za-z0-9][A-Za-z0-9_\-]{7,}/, "sk-" <> @mask)
+  end
+end
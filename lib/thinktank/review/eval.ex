@@ -52,7 +52,8 @@ defmodule Thinktank.Review.Eval do
       adapter_context: contract.adapter_context,
       trust_repo_config: Keyword.get(opts, :trust_repo_config),
       agent_config_dir:
-        Keyword.get(opts, :agent_config_dir) || agent_config_dir(contract.workspace_root),
+        Keyword.get(opts, :agent_config_dir) ||
+          Config.repo_agent_config_dir(contract.workspace_root),
       runner: Keyword.get(opts, :runner)
     ]
 
@@ -296,11 +297,4 @@ defmodule Thinktank.Review.Eval do
       failed_cases: summary.failed_cases
     })
   end
-
-  defp agent_config_dir(workspace_root) do
-    if Config.trust_repo_agent_config?() do
-      dir = Path.join(workspace_root, "agent_config")
-      if File.dir?(dir), do: dir
-    end
-  end
 end
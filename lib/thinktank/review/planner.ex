@@ -42,6 +42,7 @@ defmodule Thinktank.Review.Planner do
         agent_config_dir: opts[:agent_config_dir],
         progress_callback: opts[:progress_callback],
         progress_phase: opts[:progress_phase],
+        prompt_transform: opts[:prompt_transform],
         runner: opts[:runner]
       )
 
@@ -5,7 +5,7 @@ defmodule Thinktank.RunTracker do
 
   require Logger
 
-  alias Thinktank.{RunStore, TraceLog}
+  alias Thinktank.{Redaction, RunStore, TraceLog}
 
   @table :thinktank_run_tracker
 
@@ -15,7 +15,8 @@ defmodule Thinktank.RunTracker do
 
   @spec start(Path.t(), map()) :: :ok
   def start(output_dir, attrs \\ %{}) when is_binary(output_dir) and is_map(attrs) do
-    :ets.insert(table(), {canonical_output_dir(output_dir), normalize(attrs)})
+    attrs = attrs |> normalize() |> Map.put("secrets", Redaction.refresh_secrets())
+    :ets.insert(table(), {canonical_output_dir(output_dir), attrs})
     :ok
   end
 
@@ -40,6 +41,25 @@ defmodule Thinktank.RunTracker do
     :ok
   end
 
+  @spec tags(Path.t()) :: map() | nil
+  def tags(output_dir) when is_binary(output_dir) do
+    case :ets.lookup(table(), canonical_output_dir(output_dir)) do
+      [{_output_dir, %{"tags" => tags}}] when is_map(tags) and map_size(tags) > 0 -> tags
+      _ -> nil
+    end
+  end
+
+  # The credential values to mask in a run's artifacts, read from the
+  # environment once when the run starts. Outside a tracked run this falls
+  # back to the process-wide cache.
+  @spec secrets(Path.t()) :: [String.t()]
+  def secrets(output_dir) when is_binary(output_dir) do
+    case :ets.lookup(table(), canonical_output_dir(output_dir)) do
+      [{_output_dir, %{"secrets" => secrets}}] -> secrets
+      _ -> Redaction.secrets()
+    end
+  end
+
   @spec active_runs() :: [{String.t(), map()}]
   def active_runs do
     :ets.tab2list(table())
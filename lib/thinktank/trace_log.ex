@@ -5,6 +5,8 @@ defmodule Thinktank.TraceLog do
 
   require Logger
 
+  alias Thinktank.{Redaction, RunTracker}
+
   @events_file "trace/events.jsonl"
   @summary_file "trace/summary.json"
   @lock_table :thinktank_trace_log_locks
@@ -38,6 +40,8 @@ defmodule Thinktank.TraceLog do
           "output_dir" => Path.expand(output_dir),
           "timestamp" => now_iso8601()
         })
+        |> maybe_put_tags(output_dir)
+        |> Redaction.redact(RunTracker.secrets(output_dir))
 
       append_jsonl(events_path(output_dir), record)
       append_global_jsonl(record)
@@ -51,6 +55,7 @@ defmodule Thinktank.TraceLog do
     |> Map.put("event", event)
     |> Map.put_new("timestamp", now_iso8601())
     |> normalize_global_record()
+    |> redact_global_record()
     |> append_global_jsonl()
   rescue
     error ->
@@ -96,6 +101,15 @@ defmodule Thinktank.TraceLog do
     end
   end
 
+  # `--tag` values ride on every event of an active run so aggregated logs can
+  # be grouped by team or project without joining against the manifest.
+  defp maybe_put_tags(record, output_dir) do
+    case RunTracker.tags(output_dir) do
+      nil -> record
+      tags -> Map.put(record, "tags", tags)
+    end
+  end
+
   defp ensure_initialized(output_dir) do
     unless File.exists?(summary_path(output_dir)) do
       init_run(output_dir)
@@ -125,25 +139,36 @@ defmodule Thinktank.TraceLog do
           summary_defaults(output_dir)
         end
 
-      write_json(path, fun.(current))
+      write_json(path, fun.(current), output_dir)
     end)
   end
 
   defp write_summary(output_dir, summary) do
     summary_path(output_dir)
-    |> write_json(summary)
+    |> write_json(summary, output_dir)
   end
 
   defp append_jsonl(path, record) do
-    with_file_lock(path, fn ->
-      ensure_private_parent!(path)
-      created? = not File.exists?(path)
-      File.write!(path, Jason.encode!(record) <> "\n", [:append])
+    with_file_lock(path, fn -> write_jsonl!(path, Jason.encode!(record) <> "\n") end)
+  end
+
+  # Each event is appended unbuffered, so a crash keeps every event already
+  # recorded. `THINKTANK_TRACE_FSYNC=1` also syncs each line to disk, which
+  # survives a host crash at the cost of one fsync per event.
+  defp write_jsonl!(path, line) do
+    ensure_private_parent!(path)
+    created? = not File.exists?(path)
 
-      if created? do
-        File.chmod!(path, 0o600)
-      end
+    File.open!(path, [:append, :binary], fn file ->
+      IO.binwrite(file, line)
+      if fsync?(), do: :ok = :file.sync(file)
     end)
+
+    if created? do
+      File.chmod!(path, 0o600)
+    end
+
+    created?
   end
 
   defp append_global_jsonl(record) do
@@ -153,13 +178,47 @@ defmodule Thinktank.TraceLog do
 
       path ->
         try do
-          append_jsonl(path, record)
+          with_file_lock(path, fn ->
+            line = Jason.encode!(record) <> "\n"
+            maybe_rotate_global_log!(path, byte_size(line))
+
+            if write_jsonl!(path, line) do
+              prune_global_logs!(Path.dirname(path))
+            end
+          end)
         rescue
           _ -> :ok
         end
     end
   end
 
+  # The live file keeps its daily name; rolled files take a timestamp suffix,
+  # so descending name order is newest-first for both pruning and inspection.
+  defp maybe_rotate_global_log!(path, incoming_bytes) do
+    with max_bytes when is_integer(max_bytes) <- env_limit("THINKTANK_LOG_MAX_BYTES"),
+         {:ok, %File.Stat{size: size}} when size > 0 <- File.stat(path),
+         true <- size + incoming_bytes > max_bytes do
+      File.rename!(path, "#{Path.rootname(path)}.#{System.os_time(:microsecond)}.jsonl")
+    else
+      _ -> :ok
+    end
+  end
+
+  defp prune_global_logs!(dir) do
+    case env_limit("THINKTANK_LOG_MAX_FILES") do
+      nil ->
+        :ok
+
+      max_files ->
+        dir
+        |> Path.join("*.jsonl")
+        |> Path.wildcard()
+        |> Enum.sort(:desc)
+        |> Enum.drop(max_files)
+        |> Enum.each(&File.rm/1)
+    end
+  end
+
   defp normalize_global_record(%{"output_dir" => output_dir} = record)
        when is_binary(output_dir) do
     expanded = Path.expand(output_dir)
@@ -171,6 +230,11 @@ defmodule Thinktank.TraceLog do
 
   defp normalize_global_record(record), do: record
 
+  defp redact_global_record(%{"output_dir" => output_dir} = record),
+    do: Redaction.redact(record, RunTracker.secrets(output_dir))
+
+  defp redact_global_record(record), do: Redaction.redact(record)
+
   defp with_file_lock(path, fun) do
     key = {__MODULE__, Path.expand(path)}
     acquire_lock(key, System.monotonic_time(:millisecond) + lock_timeout_ms())
@@ -196,10 +260,11 @@ defmodule Thinktank.TraceLog do
     :ok
   end
 
-  defp write_json(path, data) do
+  defp write_json(path, data, output_dir) do
     tmp = path <> ".tmp"
     ensure_private_parent!(path)
-    File.write!(tmp, Jason.encode!(normalize(data), pretty: true))
+    data = data |> normalize() |> Redaction.redact(RunTracker.secrets(output_dir))
+    File.write!(tmp, Jason.encode!(data, pretty: true))
     File.rename!(tmp, path)
     File.chmod!(path, 0o600)
     :ok
@@ -343,7 +408,7 @@ defmodule Thinktank.TraceLog do
           "timestamp" => now_iso8601()
         })
 
-      write_json(path, summary)
+      write_json(path, summary, output_dir)
     end)
   rescue
     _ -> :ok
@@ -381,6 +446,17 @@ defmodule Thinktank.TraceLog do
     end
   end
 
+  defp fsync?, do: System.get_env("THINKTANK_TRACE_FSYNC") in ["1", "true"]
+
+  defp env_limit(name) do
+    with value when is_binary(value) <- System.get_env(name),
+         {limit, ""} when limit > 0 <- Integer.parse(value) do
+      limit
+    else
+      _ -> nil
+    end
+  end
+
   defp read_summary_or_default(path, output_dir) do
     case File.read(path) do
       {:ok, body} -> decode_summary_or_default(body, output_dir)
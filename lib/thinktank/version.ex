@@ -0,0 +1,61 @@
+defmodule Thinktank.Version do
+  @moduledoc """
+  Build and configuration identity for support reports.
+
+  The commit and build date are captured at compile time from
+  `THINKTANK_BUILD_COMMIT` and `THINKTANK_BUILD_DATE` (release builds set
+  them; local builds report `unknown`). The builtin config hash identifies the
+  embedded agent and model roster, so a stale install is visible at a glance.
+  """
+
+  alias Thinktank.{Builtin, Config}
+
+  @commit_env System.get_env("THINKTANK_BUILD_COMMIT")
+  @build_date_env System.get_env("THINKTANK_BUILD_DATE")
+  @commit @commit_env || "unknown"
+  @build_date @build_date_env || "unknown"
+
+  # Mix only recompiles on source changes; rebuild when the stamp changes too.
+  @doc false
+  def __mix_recompile__? do
+    System.get_env("THINKTANK_BUILD_COMMIT") != @commit_env or
+      System.get_env("THINKTANK_BUILD_DATE") != @build_date_env
+  end
+
+  @spec info(keyword()) :: map()
+  def info(opts \\ []) do
+    cwd = Keyword.get(opts, :cwd, File.cwd!())
+    user_path = Path.join(Config.user_config_dir(opts), "config.yml")
+    repo_path = Path.join(cwd, ".thinktank/config.yml")
+
+    %{
+      version: Application.spec(:thinktank, :vsn) |> to_string(),
+      commit: @commit,
+      build_date: @build_date,
+      builtin_config_hash: Builtin.config_hash(),
+      user_config: %{path: user_path, present: File.regular?(user_path)},
+      repo_config: %{
+        path: repo_path,
+        present: File.regular?(repo_path),
+        trusted: Config.trust_repo_config?(Keyword.get(opts, :trust_repo_config))
+      }
+    }
+  end
+
+  @spec format(map()) :: String.t()
+  def format(info) do
+    """
+    thinktank #{info.version}
+    commit: #{info.commit}
+    built: #{info.build_date}
+    builtin config: #{info.builtin_config_hash}
+    user config: #{info.user_config.path} (#{presence(info.user_config)})
+    repo config: #{info.repo_config.path} (#{presence(info.repo_config)})
+    """
+    |> String.trim_trailing()
+  end
+
+  defp presence(%{present: false}), do: "absent"
+  defp presence(%{trusted: false}), do: "present, untrusted"
+  defp presence(_config), do: "present"
+end
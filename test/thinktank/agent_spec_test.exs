@@ -89,6 +89,93 @@ defmodule Thinktank.AgentSpecTest do
     assert spec.metadata == %{"role" => "correctness"}
   end
 
+  test "parses retry backoff settings from the spec or shared agent defaults" do
+    raw = %{
+      "provider" => "openrouter",
+      "model" => "openai/gpt-5.4",
+      "system_prompt" => "You are trace.",
+      "thinking_level" => "high"
+    }
+
+    assert {:ok, spec} = AgentSpec.from_pair("trace", raw)
+    assert {spec.retry_delay_ms, spec.retry_multiplier, spec.retry_max_delay_ms} ==
+             {250, 2, 10_000}
+
+    assert {:ok, spec} =
+             AgentSpec.from_pair(
+               "trace",
+               Map.merge(raw, %{"retry_delay_ms" => "500", "retry_multiplier" => "1.5"}),
+               %{"retry_max_delay_ms" => 4_000, "retry_delay_ms" => 100}
+             )
+
+    assert {spec.retry_delay_ms, spec.retry_multiplier, spec.retry_max_delay_ms} ==
+             {500, 1.5, 4_000}
+
+    assert {:error, "agent retry_multiplier must be a number >= 1"} =
+             AgentSpec.from_pair("trace", Map.put(raw, "retry_multiplier", 0.5))
+  end
+
+  test "parses the vision flag" do
+    raw = %{
+      "provider" => "openrouter",
+      "model" => "openai/gpt-5.4",
+      "system_prompt" => "You are trace.",
+      "thinking_level" => "high"
+    }
+
+    assert {:ok, %AgentSpec{vision: false}} = AgentSpec.from_pair("trace", raw)
+
+    assert {:ok, %AgentSpec{vision: true}} =
+             AgentSpec.from_pair("trace", Map.put(raw, "vision", true))
+
+    assert {:error, "agent vision must be true or false"} =
+             AgentSpec.from_pair("trace", Map.put(raw, "vision", "yes"))
+  end
+
+  test "parses the retry_on categories" do
+    raw = %{
+      "provider" => "openrouter",
+      "model" => "openai/gpt-5.4",
+      "system_prompt" => "You are trace.",
+      "thinking_level" => "high"
+    }
+
+    assert {:ok, %AgentSpec{retry_on: [:crash, :empty_output]}} =
+             AgentSpec.from_pair("trace", raw)
+
+    assert {:ok, %AgentSpec{retry_on: [:crash, :timeout]}} =
+             AgentSpec.from_pair("trace", Map.put(raw, "retry_on", ["crash", "timeout"]))
+
+    assert {:ok, %AgentSpec{retry_on: [:empty_output]}} =
+             AgentSpec.from_pair("trace", Map.put(raw, "retry_on", "empty_output"))
+
+    assert {:ok, %AgentSpec{retry_on: []}} =
+             AgentSpec.from_pair("trace", Map.put(raw, "retry_on", []))
+
+    assert {:error, "agent retry_on must list only crash, timeout, empty_output"} =
+             AgentSpec.from_pair("trace", Map.put(raw, "retry_on", ["rate_limit"]))
+  end
+
+  test "parses an optional fallback model" do
+    raw = %{
+      "provider" => "openrouter",
+      "model" => "openai/gpt-5.4",
+      "system_prompt" => "You are trace.",
+      "thinking_level" => "high"
+    }
+
+    assert {:ok, %AgentSpec{fallback_model: nil}} = AgentSpec.from_pair("trace", raw)
+
+    assert {:ok, %AgentSpec{fallback_model: "openai/gpt-5.4-mini"}} =
+             AgentSpec.from_pair("trace", Map.put(raw, "fallback_model", "openai/gpt-5.4-mini"))
+
+    assert {:error, "agent fallback_model must not contain whitespace"} =
+             AgentSpec.from_pair("trace", Map.put(raw, "fallback_model", "bad model"))
+
+    assert {:error, "agent fallback_model must be a string"} =
+             AgentSpec.from_pair("trace", Map.put(raw, "fallback_model", 42))
+  end
+
   test "rejects non-map specs and invalid numeric fields" do
     assert {:error, "agent trace must be a map"} = AgentSpec.from_pair("trace", nil)
 
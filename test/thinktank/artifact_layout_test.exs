@@ -32,6 +32,8 @@ defmodule Thinktank.ArtifactLayoutTest do
     assert "review/context.json" in contract.files
     assert "scratchpads/run.md" in contract.files
     assert "agents/{instance_id}.md" in contract.dynamic_files
+    assert "failures/{instance_id}.json" in contract.dynamic_files
+    assert "prompts/{instance_id}.md" in contract.dynamic_files
     assert Enum.uniq(paths) == paths
     assert ArtifactLayout.validate_path_contract() == :ok
 
@@ -16,6 +16,7 @@ defmodule Thinktank.CLIEngineBoundaryTest do
     paths_root = Path.join(cwd, "lib")
 
     File.mkdir_p!(paths_root)
+    File.write!(Path.join(paths_root, "app.ex"), "defmodule App, do: nil\n")
 
     assert {:ok, command} =
              CLI.parse_args([
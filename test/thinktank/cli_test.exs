@@ -3,7 +3,8 @@ defmodule Thinktank.CLITest do
 
   import ExUnit.CaptureIO
 
-  alias Thinktank.{BenchSpec, CLI, Config, RunContract, RunStore}
+  alias Thinktank.{BenchSpec, CLI, Config, Error, RunContract, RunStore}
+  alias Thinktank.CLI.{ExitClass, InputError, Render, RunSummary, StreamLines}
 
   @exit_codes CLI.exit_codes()
 
@@ -57,6 +58,146 @@ defmodule Thinktank.CLITest do
     assert command.input.agents == ["systems", "dx"]
   end
 
+  test "collapses repeated --agents entries with a warning unless duplicates are allowed" do
+    assert {:ok, command} =
+             CLI.parse_args(["research", "audit", "--agents", "systems,dx,systems"])
+
+    assert command.input.agents == ["systems", "dx"]
+    assert [warning] = command.warnings
+    assert warning =~ "agent systems was listed more than once"
+
+    assert {:ok, command} =
+             CLI.parse_args([
+               "research",
+               "audit",
+               "--agents",
+               "systems,systems",
+               "--allow-duplicates"
+             ])
+
+    assert command.input.agents == ["systems", "systems"]
+    assert command.warnings == []
+  end
+
+  test "prints duplicate agent warnings to stderr before running" do
+    {:ok, command} =
+      CLI.parse_args(["research", "audit", "--agents", "systems,systems", "--dry-run"])
+
+    stderr =
+      capture_io(:stderr, fn ->
+        capture_io(fn -> assert CLI.execute({:ok, command}) == 0 end)
+      end)
+
+    assert stderr =~ "Warning: agent systems was listed more than once"
+  end
+
+  test "--quiet drops CLI warnings and lowers the log level for the run only" do
+    previous_level = Logger.level()
+    on_exit(fn -> Logger.configure(level: previous_level) end)
+    Logger.configure(level: :info)
+
+    {:ok, command} =
+      CLI.parse_args(["research", "audit", "-q", "--agents", "systems,systems", "--dry-run"])
+
+    assert command.log_level == :error
+    assert command.warnings == []
+
+    stderr =
+      capture_io(:stderr, fn ->
+        capture_io(fn -> assert CLI.execute({:ok, command}) == 0 end)
+      end)
+
+    assert stderr == ""
+    assert Logger.level() == :info
+  end
+
+  test "parses --progress modes for text runs" do
+    assert {:ok, %{text_progress: true}} =
+             CLI.parse_args(["research", "audit", "--progress", "always"])
+
+    assert {:ok, %{text_progress: false}} =
+             CLI.parse_args(["research", "audit", "--progress", "never"])
+
+    assert {:ok, %{text_progress: false}} =
+             CLI.parse_args(["research", "audit", "--progress", "always", "--quiet"])
+
+    assert {:ok, %{text_progress: false}} =
+             CLI.parse_args(["research", "audit", "--progress", "always", "--json"])
+
+    assert {:error, :invalid_flag_value, "--progress must be always, never, or auto"} =
+             CLI.parse_args(["research", "audit", "--progress", "sometimes"])
+  end
+
+  test "renders per-agent progress lines with elapsed time" do
+    ok = %{"agent_name" => "dx", "status" => "ok"}
+    error = %{"agent_name" => "dx", "status" => "error"}
+
+    assert Render.progress_line("agent_started", %{"agent_name" => "systems"}, 1_240) ==
+             "[1.2s] systems: running"
+
+    retrying = %{"agent_name" => "dx", "attempt" => 2, "max_attempts" => 3}
+
+    assert Render.progress_line("agent_retrying", retrying, 0) ==
+             "[0.0s] dx: retrying (attempt 2/3)"
+
+    assert Render.progress_line("agent_finished", ok, 0) == "[0.0s] dx: done"
+    assert Render.progress_line("agent_finished", error, 0) == "[0.0s] dx: failed"
+    assert Render.progress_line("agent_output", %{"agent_name" => "dx", "chunk" => "x"}, 0) == nil
+  end
+
+  test "parses --stream for run commands" do
+    assert {:ok, %{action: :run, stream: true}} =
+             CLI.parse_args(["research", "audit", "--stream"])
+    assert {:ok, %{action: :run, stream: false}} = CLI.parse_args(["research", "audit"])
+  end
+
+  test "--stream prints whole lines per agent across chunk boundaries" do
+    stream = StreamLines.start()
+    dx = %{"agent_name" => "dx", "instance_id" => "dx-1"}
+    systems = %{"agent_name" => "systems", "instance_id" => "systems-1"}
+
+    assert StreamLines.lines(stream, "agent_output", Map.put(dx, "chunk", "first li")) == []
+
+    assert StreamLines.lines(stream, "agent_output", Map.put(systems, "chunk", "other\n")) ==
+             ["[systems] other"]
+
+    assert StreamLines.lines(stream, "agent_output", Map.put(dx, "chunk", "ne\n\nsecond")) ==
+             ["[dx] first line", "[dx]"]
+
+    assert StreamLines.lines(stream, "agent_retrying", dx) == ["[dx] second"]
+    assert StreamLines.lines(stream, "agent_output", Map.put(dx, "chunk", "retry")) == []
+    assert StreamLines.lines(stream, "agent_finished", dx) == ["[dx] retry"]
+    assert StreamLines.lines(stream, "agent_finished", systems) == []
+
+    assert StreamLines.lines(stream, "agent_output", Map.put(systems, "chunk", "cut off")) == []
+    assert StreamLines.stop(stream) == ["[systems] cut off"]
+    refute Process.alive?(stream)
+    assert StreamLines.stop(nil) == []
+  end
+
+  test "parses repeatable --tag entries and rejects malformed keys" do
+    assert {:ok, %{input: %{tags: tags}}} =
+             CLI.parse_args([
+               "research",
+               "inspect",
+               "--tag",
+               "team=infra",
+               "--tag",
+               "cost.center=ml-42",
+               "--tag",
+               "team=platform"
+             ])
+
+    assert tags == %{"team" => "platform", "cost.center" => "ml-42"}
+    assert {:ok, command} = CLI.parse_args(["research", "inspect"])
+    refute Map.has_key?(command.input, :tags)
+
+    for bad <- ["team", "team=", "1team=infra", "team name=infra"] do
+      assert {:error, :invalid_flag_value, "--tag must be key=value" <> _} =
+               CLI.parse_args(["research", "inspect", "--tag", bad])
+    end
+  end
+
   test "parses review subcommand flags" do
     assert {:ok, command} =
              CLI.parse_args([
@@ -96,7 +237,7 @@ defmodule Thinktank.CLITest do
   end
 
   test "requires --repo when --pr is provided for review" do
-    assert {:error, "review/default requires --repo when --pr is provided"} =
+    assert {:error, :invalid_flag_value, "review/default requires --repo when --pr is provided"} =
              CLI.parse_args(["review", "--pr", "42"])
   end
 
@@ -190,7 +331,8 @@ defmodule Thinktank.CLITest do
           default_task: Review the change
       """,
       fn ->
-        assert {:error, "demo/review requires --repo when --pr is provided"} =
+        assert {:error, :invalid_flag_value,
+                "demo/review requires --repo when --pr is provided"} =
                  CLI.parse_args([
                    "run",
                    "demo/review",
@@ -221,10 +363,50 @@ defmodule Thinktank.CLITest do
     assert {:ok, %{action: :runs_wait, target: "./tmp/run", timeout_ms: 250}} =
              CLI.parse_args(["runs", "wait", "./tmp/run", "--timeout-ms", "250"])
 
-    assert {:error, "--timeout-ms must be a non-negative integer"} =
+    assert {:error, :invalid_flag_value, "--timeout-ms must be a non-negative integer"} =
              CLI.parse_args(["runs", "wait", "./tmp/run", "--timeout-ms", "-1"])
   end
 
+  test "--version reports build metadata and the builtin config hash" do
+    vsn = Application.spec(:thinktank, :vsn) |> to_string()
+    assert {:version, %{json: false} = command} = CLI.parse_args(["--version"])
+
+    output =
+      capture_io(fn ->
+        assert CLI.execute({:version, command}) == @exit_codes.success
+      end)
+
+    assert output =~ "thinktank #{vsn}\n"
+    assert output =~ "commit: "
+    assert output =~ "builtin config: "
+
+    assert {:version, %{json: true} = json_command} = CLI.parse_args(["--version", "--json"])
+
+    json_output =
+      capture_io(fn ->
+        assert CLI.execute({:version, json_command}) == @exit_codes.success
+      end)
+
+    assert {:ok, decoded} = Jason.decode(String.trim(json_output))
+    assert decoded["version"] == vsn
+    assert is_binary(decoded["commit"])
+    assert decoded["builtin_config_hash"] =~ ~r/^[0-9a-f]{12}$/
+  end
+
+  test "--version reports repo config as trusted under --trust-repo-config" do
+    tmp = unique_tmp_dir("thinktank-cli-version")
+    File.mkdir_p!(Path.join(tmp, ".thinktank"))
+    File.write!(Path.join(tmp, ".thinktank/config.yml"), "agents: {}\n")
+
+    File.cd!(tmp, fn ->
+      for {args, trusted} <- [{[], false}, {["--trust-repo-config"], true}] do
+        {:version, command} = CLI.parse_args(["--version", "--json" | args])
+        output = capture_io(fn -> CLI.execute({:version, command}) end)
+        assert Jason.decode!(output)["repo_config"]["trusted"] == trusted
+      end
+    end)
+  end
+
   test "benches validate prints JSON when --json is requested" do
     {:ok, config} = Config.load()
     expected_count = length(Config.list_benches(config))
@@ -300,6 +482,94 @@ defmodule Thinktank.CLITest do
              decoded["warnings"]
   end
 
+  test "benches validate checks credentials for a synthesizer on an unprobed provider" do
+    in_tmp_repo_config(
+      """
+      agents:
+        direct-synth:
+          provider: anthropic
+          model: claude-sonnet-4
+          system_prompt: Synthesize the panel
+      benches:
+        demo/research:
+          description: Demo research bench
+          agents:
+            - systems
+          synthesizer: direct-synth
+      """,
+      fn ->
+        assert {:ok, command} =
+                 CLI.parse_args(["benches", "validate", "--trust-repo-config", "--json"])
+
+        command =
+          command
+          |> capability_success_command()
+          |> Map.put(:capability_env_reader, fn name ->
+            if name =~ "OPENROUTER", do: "test-openrouter-key"
+          end)
+
+        output =
+          capture_io(fn ->
+            assert CLI.execute({:ok, command}) == @exit_codes.success
+          end)
+
+        assert {:ok, decoded} = Jason.decode(String.trim(output))
+        assert decoded["status"] == "ok"
+
+        assert [
+                 %{
+                   "code" => "provider_credentials_missing",
+                   "provider" => "anthropic",
+                   "credential_env" => "THINKTANK_ANTHROPIC_API_KEY",
+                   "message" => message
+                 }
+               ] = decoded["warnings"]
+
+        assert message =~ "will fail at launch"
+      end
+    )
+  end
+
+  test "benches validate checks credentials for a tool agent whose adapter has no probe" do
+    in_tmp_repo_config(
+      """
+      agents:
+        direct-reviewer:
+          provider: anthropic
+          model: claude-sonnet-4
+          system_prompt: Review the change
+          tools: [read, grep]
+      benches:
+        demo/review:
+          description: Demo review bench
+          agents:
+            - direct-reviewer
+      """,
+      fn ->
+        assert {:ok, command} =
+                 CLI.parse_args(["benches", "validate", "--trust-repo-config", "--json"])
+
+        command = Map.put(command, :capability_env_reader, fn _name -> nil end)
+
+        output =
+          capture_io(fn ->
+            assert CLI.execute({:ok, command}) == @exit_codes.success
+          end)
+
+        assert {:ok, decoded} = Jason.decode(String.trim(output))
+        anthropic = Enum.filter(decoded["warnings"], &(&1["provider"] == "anthropic"))
+
+        assert Enum.map(anthropic, & &1["code"]) |> Enum.sort() == [
+                 "provider_capability_probe_unsupported",
+                 "provider_credentials_missing"
+               ]
+
+        assert Enum.find(anthropic, &(&1["code"] == "provider_credentials_missing"))["message"] =~
+                 "will fail at launch"
+      end
+    )
+  end
+
   test "benches validate emits a warning instead of crashing when a capability probe times out" do
     in_tmp_repo_config(
       """
@@ -357,6 +627,57 @@ defmodule Thinktank.CLITest do
     )
   end
 
+  test "--env-file supplies provider keys missing from the real environment" do
+    names = ["THINKTANK_OPENROUTER_API_KEY", "OPENROUTER_API_KEY", "THINKTANK_ENV_FILE_KEPT"]
+    previous = Map.new(names, &{&1, System.get_env(&1)})
+
+    on_exit(fn ->
+      Enum.each(previous, fn
+        {name, nil} -> System.delete_env(name)
+        {name, value} -> System.put_env(name, value)
+      end)
+    end)
+
+    Enum.each(names, &System.delete_env/1)
+    System.put_env("THINKTANK_ENV_FILE_KEPT", "from-shell")
+
+    env_file = Path.join(unique_tmp_dir("thinktank-cli-env-file"), "thinktank.env")
+
+    File.write!(env_file, """
+    # provider keys
+    OPENROUTER_API_KEY="test-openrouter-key"
+    THINKTANK_ENV_FILE_KEPT=from-file
+    """)
+
+    {:ok, command} = CLI.parse_args(["benches", "validate", "--json", "--env-file", env_file])
+    assert command.env_file == env_file
+    assert System.get_env("OPENROUTER_API_KEY") == nil
+
+    command =
+      Map.put(command, :capability_probe, fn _provider, _model, required_capabilities, _opts ->
+        {:ok, required_capabilities}
+      end)
+
+    output =
+      capture_io(fn ->
+        assert CLI.execute({:ok, command}) == @exit_codes.success
+      end)
+
+    assert {:ok, %{"status" => "ok", "warnings" => []}} = Jason.decode(String.trim(output))
+    assert System.get_env("OPENROUTER_API_KEY") == "test-openrouter-key"
+    assert System.get_env("THINKTANK_ENV_FILE_KEPT") == "from-shell"
+
+    assert {:ok, command} =
+             CLI.parse_args(["research", "audit", "--env-file", env_file <> ".missing"])
+
+    output =
+      capture_io(:stderr, fn ->
+        assert CLI.execute({:ok, command}) == @exit_codes.input_error
+      end)
+
+    assert output =~ "cannot read env file"
+  end
+
   test "benches validate emits typed JSON errors for missing provider capabilities" do
     in_tmp_repo_config(
       """
@@ -456,19 +777,59 @@ defmodule Thinktank.CLITest do
   end
 
   test "rejects malformed reserved subcommands" do
-    assert {:error, "run requires a bench id"} = CLI.parse_args(["run"])
+    assert {:error, :missing_bench, "run requires a bench id"} = CLI.parse_args(["run"])
 
-    assert {:error, "benches expects list, show <bench>, or validate"} =
+    assert {:error, :invalid_command, "benches expects list, show <bench>, or validate"} =
              CLI.parse_args(["benches", "show", "research/default", "extra"])
 
-    assert {:error, "runs expects list, show <path-or-id>, or wait <path-or-id>"} =
+    assert {:error, :invalid_command,
+            "runs expects list, show <path-or-id>, or wait <path-or-id>"} =
              CLI.parse_args(["runs", "show"])
   end
 
+  test "parse errors carry a stable code in --json mode" do
+    stderr =
+      capture_io(:stderr, fn ->
+        assert ["research", "audit", "--json", "--bogus"]
+               |> CLI.parse_args()
+               |> InputError.with_format(["research", "audit", "--json", "--bogus"])
+               |> CLI.execute() == @exit_codes.input_error
+      end)
+
+    assert %{"error" => %{"code" => "unknown_flag", "message" => "unknown flag: --bogus"}} =
+             Jason.decode!(String.trim(stderr))
+  end
+
+  test "parse errors keep their human message without --json" do
+    stderr =
+      capture_io(:stderr, fn ->
+        assert CLI.execute(CLI.parse_args(["run"])) == @exit_codes.input_error
+      end)
+
+    assert stderr == "Error: run requires a bench id\n"
+  end
+
+  test "parser failures carry their error code" do
+    codes = %{
+      ["research", "audit", "--bogus"] => :unknown_flag,
+      ["runs", "wait", "./tmp/run", "--timeout-ms", "-1"] => :invalid_flag_value,
+      ["research", "audit", "--tag", "nope"] => :invalid_flag_value,
+      ["run"] => :missing_bench,
+      ["run", "missing/bench", "--input", "x"] => :unknown_bench,
+      ["runs", "show"] => :invalid_command,
+      ["review", "eval"] => :invalid_command
+    }
+
+    for {args, code} <- codes do
+      assert {:error, ^code, message} = CLI.parse_args(args)
+      assert is_binary(message)
+    end
+  end
+
   test "read_stdin fails fast when stdin is interactive" do
     command = %{bench_id: "research/default", input: %{input_text: nil}}
 
-    assert {:error, "input text is required"} =
+    assert {:error, :missing_input_text, "input text is required"} =
              CLI.read_stdin(command,
                stdin_piped?: false,
                reader: fn _, _ -> flunk("stdin reader should not run without piped input") end
@@ -476,7 +837,7 @@ defmodule Thinktank.CLITest do
   end
 
   test "read_stdin trims piped input" do
-    command = %{bench_id: "research/default", input: %{input_text: nil}}
+    {:needs_stdin, command} = CLI.parse_args(["research"])
 
     assert {:ok, updated} =
              CLI.read_stdin(command,
@@ -487,6 +848,129 @@ defmodule Thinktank.CLITest do
     assert updated.input.input_text == "inspect this branch"
   end
 
+  test "read_stdin replaces invalid UTF-8 and warns" do
+    {:needs_stdin, command} = CLI.parse_args(["research"])
+
+    assert {:ok, updated} =
+             CLI.read_stdin(command,
+               stdin_piped?: true,
+               reader: fn :stdio, :eof -> "caf" <> <<0xE9>> <> " menu\n" end
+             )
+
+    assert updated.input.input_text == "caf\uFFFD menu"
+    assert [warning] = updated.warnings
+    assert warning =~ "invalid UTF-8"
+
+    assert {:ok, %{warnings: []}} =
+             CLI.read_stdin(command, stdin_piped?: true, reader: fn :stdio, :eof -> "café" end)
+
+    assert {:ok, quiet} =
+             CLI.read_stdin(Map.put(command, :log_level, :error),
+               stdin_piped?: true,
+               reader: fn :stdio, :eof -> <<0xFF>> <> "x" end
+             )
+
+    assert quiet.warnings == []
+  end
+
+  test "--input text with invalid UTF-8 is repaired before the run" do
+    {:ok, command} = CLI.parse_args(["research", "--input", "bad " <> <<0xC3, 0x28>>])
+
+    assert String.valid?(command.input.input_text)
+    assert command.input.input_text == "bad \uFFFD("
+    assert Enum.any?(command.warnings, &(&1 =~ "invalid UTF-8"))
+  end
+
+  test "--front-matter reads per-run settings and strips the block from the task" do
+    text = """
+    ---
+    agents: [systems, dx]
+    no_synthesis: true
+    ---
+    Audit the retry loop.
+    """
+
+    assert {:ok, command} = CLI.parse_args(["research", "--front-matter", "--input", text])
+    assert command.input.input_text == "Audit the retry loop."
+    assert command.input.agents == ["systems", "dx"]
+    assert command.input.no_synthesis == true
+
+    assert {:ok, command} =
+             CLI.parse_args(["research", "--front-matter", "--agents", "verification", text])
+
+    assert command.input.agents == ["verification"]
+
+    assert {:ok, command} = CLI.parse_args(["research", "--input", text])
+    assert command.input.input_text == String.trim(text)
+    assert command.input.agents == []
+  end
+
+  test "--front-matter applies to piped stdin and rejects unknown keys" do
+    {:needs_stdin, command} = CLI.parse_args(["research", "--front-matter"])
+
+    assert {:ok, updated} =
+             CLI.read_stdin(command,
+               stdin_piped?: true,
+               reader: fn :stdio, :eof -> "---\nagents: systems\n---\nInspect lib/\n" end
+             )
+
+    assert updated.input.input_text == "Inspect lib/"
+    assert updated.input.agents == ["systems"]
+
+    image = Path.join(unique_tmp_dir("thinktank-cli-front-matter"), "screen.png")
+    File.write!(image, "png")
+    {:needs_stdin, command} = CLI.parse_args(["research", "--front-matter", "--attach", image])
+
+    assert {:ok, updated} =
+             CLI.read_stdin(command,
+               stdin_piped?: true,
+               reader: fn :stdio, :eof -> "---\nagents: [ml, dx, ml]\n---\nInspect\n" end
+             )
+
+    assert updated.input.agents == ["ml", "dx"]
+    assert Enum.any?(updated.warnings, &(&1 =~ "agent ml was listed more than once"))
+    assert "attachments skipped for text-only agents: ml" in updated.warnings
+
+    args = ["research", "--front-matter", "--input", "---\nmodels: [a]\n---\nx"]
+
+    assert {:error, :invalid_front_matter,
+            "front matter supports agents, no_synthesis; unknown keys: models"} =
+             CLI.parse_args(args)
+
+    assert {:error, :missing_input_text, "input text is required"} =
+             CLI.parse_args(["research", "--front-matter", "--input", "---\nagents: [dx]\n---\n"])
+  end
+
+  test "--attach validates images and warns about text-only agents" do
+    tmp = unique_tmp_dir("thinktank-cli-attach")
+    image = Path.join(tmp, "screen.PNG")
+    notes = Path.join(tmp, "notes.txt")
+    File.write!(image, "png")
+    File.write!(notes, "notes")
+
+    assert {:ok, command} = CLI.parse_args(["research", "audit the UI", "--attach", image])
+    assert command.input.attachments == [image]
+    assert "attachments skipped for text-only agents: verification, ml" in command.warnings
+
+    assert {:ok, command} =
+             CLI.parse_args(["research", "audit", "--attach", image, "--agents", "systems,dx"])
+
+    assert command.warnings == []
+
+    assert {:ok, command} = CLI.parse_args(["research", "audit"])
+    refute Map.has_key?(command.input, :attachments)
+
+    assert {:error, :invalid_attachment, "--attach " <> message} =
+             CLI.parse_args(["research", "audit", "--attach", notes])
+
+    assert message =~ "only .png"
+
+    assert {:error, :invalid_attachment, "--attach " <> missing} =
+             CLI.parse_args(["research", "audit", "--attach", Path.join(tmp, "gone.png")])
+
+    assert missing =~ "not a readable file"
+  end
+
   test "dry run prints bench-oriented JSON contract" do
     {:ok, command} =
       CLI.parse_args(["research", "test prompt", "--dry-run", "--json", "--paths", "./lib"])
@@ -514,6 +998,250 @@ defmodule Thinktank.CLITest do
     assert output =~ "Input: test prompt"
   end
 
+  test "print prompt renders each agent prompt without launching or writing artifacts" do
+    output_dir = Path.join(unique_tmp_dir("thinktank-cli-print-prompt"), "run")
+
+    {:ok, command} =
+      CLI.parse_args([
+        "research",
+        "test prompt",
+        "--print-prompt",
+        "--json",
+        "--agents",
+        "systems,dx",
+        "--paths",
+        "./lib",
+        "--output",
+        output_dir
+      ])
+
+    output =
+      capture_io(fn ->
+        assert CLI.execute({:ok, command}) == 0
+      end)
+
+    assert {:ok, %{"prompts" => prompts}} = Jason.decode(String.trim(output))
+    assert Enum.map(prompts, & &1["agent"]) == ["systems", "dx"]
+    assert Enum.all?(prompts, &(&1["prompt"] =~ "test prompt"))
+    assert Enum.all?(prompts, &(&1["prompt"] =~ Path.expand("./lib")))
+    refute File.exists?(output_dir)
+  end
+
+  test "print prompt emits agent-delimited text unless --json is requested" do
+    {:ok, command} =
+      CLI.parse_args(["research", "test prompt", "--print-prompt", "--agents", "systems"])
+
+    output =
+      capture_io(fn ->
+        assert CLI.execute({:ok, command}) == 0
+      end)
+
+    assert output =~ "==> systems <=="
+    assert output =~ "test prompt"
+  end
+
+  test "print config reflects repo config overrides, agent selection, and output dir" do
+    output_dir = Path.join(unique_tmp_dir("thinktank-cli-print-config"), "run")
+    previous = System.get_env("THINKTANK_OPENROUTER_API_KEY")
+    System.put_env("THINKTANK_OPENROUTER_API_KEY", "sk-secret-value")
+
+    on_exit(fn ->
+      if previous,
+        do: System.put_env("THINKTANK_OPENROUTER_API_KEY", previous),
+        else: System.delete_env("THINKTANK_OPENROUTER_API_KEY")
+    end)
+
+    in_tmp_repo_config(
+      """
+      agents:
+        systems:
+          model: example/override-model
+          retries: 2
+      """,
+      fn ->
+        {:ok, command} =
+          CLI.parse_args([
+            "research",
+            "test prompt",
+            "--print-config",
+            "--json",
+            "--trust-repo-config",
+            "--agents",
+            "systems",
+            "--output",
+            output_dir
+          ])
+
+        output =
+          capture_io(fn ->
+            assert CLI.execute({:ok, command}) == 0
+          end)
+
+        refute output =~ "sk-secret-value"
+        assert {:ok, payload} = Jason.decode(String.trim(output))
+        assert payload["bench"] == "research/default"
+        assert payload["output_dir"] == output_dir
+        assert [%{"name" => "systems"} = systems] = payload["agents"]
+        assert systems["model"] == "example/override-model"
+        assert systems["retries"] == 2
+        assert payload["providers"][systems["provider"]]["credential"] == "set"
+        assert payload["config_sources"]["repo_trusted"] == true
+        refute File.exists?(output_dir)
+      end
+    )
+  end
+
+  test "--validate fails a dry run when a selected agent's provider key is unset" do
+    names = ["THINKTANK_OPENROUTER_API_KEY", "OPENROUTER_API_KEY"]
+    previous = Map.new(names, &{&1, System.get_env(&1)})
+
+    on_exit(fn ->
+      Enum.each(previous, fn
+        {name, nil} -> System.delete_env(name)
+        {name, value} -> System.put_env(name, value)
+      end)
+    end)
+
+    Enum.each(names, &System.delete_env/1)
+
+    {:ok, command} =
+      CLI.parse_args(["research", "test prompt", "--validate", "--json", "--agents", "systems"])
+
+    assert command.dry_run
+
+    stderr =
+      capture_io(:stderr, fn ->
+        assert capture_io(fn ->
+                 assert CLI.execute({:ok, command}) == @exit_codes.input_error
+               end) == ""
+      end)
+
+    assert {:ok, %{"error" => error}} = Jason.decode(String.trim(stderr))
+    assert error["code"] == "provider_credentials_missing"
+    assert error["message"] =~ "THINKTANK_OPENROUTER_API_KEY or OPENROUTER_API_KEY"
+
+    {:ok, unknown} = CLI.parse_args(["research", "test prompt", "--validate", "--agents", "nope"])
+
+    assert capture_io(:stderr, fn ->
+             assert CLI.execute({:ok, unknown}) == @exit_codes.input_error
+           end) =~ "nope"
+
+    System.put_env("OPENROUTER_API_KEY", "test-openrouter-key")
+
+    output =
+      capture_io(fn ->
+        assert CLI.execute({:ok, command}) == @exit_codes.success
+      end)
+
+    assert {:ok, %{"agents" => ["systems"]}} = Jason.decode(String.trim(output))
+  end
+
+  test "--paths-from appends listed paths after --paths flags" do
+    dir = unique_tmp_dir("thinktank-cli-paths-from")
+    list = Path.join(dir, "changed.txt")
+
+    a = Path.join(dir, "a.ex")
+    b = Path.join(dir, "b.ex")
+    Enum.each([a, b], &File.write!(&1, "defmodule X, do: nil\n"))
+
+    File.write!(list, """
+    # from git diff --name-only
+    #{a}
+
+      #{b}
+    """)
+
+    assert {:ok, command} =
+             CLI.parse_args(["research", "audit", "--paths", "./README.md", "--paths-from", list])
+
+    assert command.input.paths == [Path.expand("./README.md"), a, b]
+
+    assert {:error, :unreadable_paths_from, "cannot read --paths-from " <> _reason} =
+             CLI.parse_args(["research", "audit", "--paths-from", list <> ".missing"])
+  end
+
+  test "--paths-from - reads the list from stdin and needs the task text inline" do
+    dir = unique_tmp_dir("thinktank-cli-paths-from-stdin")
+    a = Path.join(dir, "a.ex")
+    File.write!(a, "defmodule X, do: nil\n")
+
+    assert {:needs_stdin, pending} =
+             CLI.parse_args(["research", "audit", "--paths", "./README.md", "--paths-from", "-"])
+
+    assert {:ok, command} =
+             CLI.read_stdin(pending, stdin_piped?: true, reader: fn :stdio, :eof -> "#{a}\n" end)
+
+    assert command.input.paths == [Path.expand("./README.md"), a]
+    assert command.input.input_text == "audit"
+
+    assert {:error, :unreadable_paths_from, "cannot read --paths-from -" <> _reason} =
+             CLI.read_stdin(pending,
+               stdin_piped?: false,
+               reader: fn _, _ -> flunk("stdin reader should not run without piped input") end
+             )
+
+    assert {:error, :invalid_flag_value, "--paths-from - reads stdin" <> _rest} =
+             CLI.parse_args(["research", "--paths-from", "-"])
+  end
+
+  test "--paths rejects missing paths and paths with no content unless --allow-empty" do
+    workspace = unique_tmp_dir("thinktank-cli-empty-paths")
+    empty_dir = Path.join(workspace, "empty")
+    File.mkdir_p!(Path.join(empty_dir, "nested"))
+    File.write!(Path.join(empty_dir, "nested/blank.txt"), "  \n")
+    File.write!(Path.join(empty_dir, "nested/padding.txt"), String.duplicate(" \n", 10_000))
+
+    assert {:error, :empty_paths, "no content found under --paths " <> message} =
+             CLI.parse_args(["research", "audit", "--paths", empty_dir, "--dry-run"])
+
+    assert message =~ empty_dir
+    assert message =~ "--allow-empty"
+
+    assert {:ok, %{input: %{paths: [^empty_dir]}}} =
+             CLI.parse_args(["research", "audit", "--paths", empty_dir, "--allow-empty"])
+
+    missing = Path.join(workspace, "missing")
+
+    assert {:error, :missing_paths, "--paths entries do not exist: " <> ^missing} =
+             CLI.parse_args(["research", "audit", "--paths", missing, "--allow-empty"])
+
+    assert {:ok, %{action: :benches_list}} =
+             CLI.parse_args(["benches", "list", "--paths", missing])
+
+    File.write!(Path.join(empty_dir, "notes.md"), "real content\n")
+
+    assert {:ok, _command} = CLI.parse_args(["research", "audit", "--paths", empty_dir])
+  end
+
+  test "--paths warns about secret-looking files and --refuse-secrets rejects them" do
+    workspace = unique_tmp_dir("thinktank-cli-secret-paths")
+    File.write!(Path.join(workspace, ".env"), "API_KEY=fake\n")
+    File.write!(Path.join(workspace, ".env.example"), "API_KEY=\n")
+    File.write!(Path.join(workspace, "README.md"), "docs\n")
+
+    assert {:ok, command} =
+             CLI.parse_args(["research", "audit", "--paths", workspace, "--dry-run"])
+
+    assert [warning] = command.warnings
+    assert warning =~ Path.join(workspace, ".env")
+    refute warning =~ ".env.example"
+    refute warning =~ "README.md"
+
+    assert {:error, :secret_paths, "refusing secret-looking paths: " <> paths} =
+             CLI.parse_args(["research", "audit", "--paths", workspace, "--refuse-secrets"])
+
+    assert paths == Path.join(workspace, ".env")
+
+    assert {:ok, %{warnings: []}} =
+             CLI.parse_args([
+               "research",
+               "audit",
+               "--paths",
+               Path.join(workspace, "README.md"),
+               "--refuse-secrets"
+             ])
+  end
+
   test "dry run JSON includes planner metadata for review benches" do
     {:ok, command} = CLI.parse_args(["review", "--dry-run", "--json"])
 
@@ -612,6 +1340,36 @@ defmodule Thinktank.CLITest do
     end
   end
 
+  test "maps run outcomes to distinct exit classes" do
+    timeout = %{status: :error, error: %{category: :timeout}}
+    crash = %{status: :error, error: %{category: :crash}}
+    partial = %{envelope: %{status: "partial"}, results: [], synthesis: nil}
+
+    assert ExitClass.for_run(%{envelope: %{status: "complete"}}) == :success
+    assert ExitClass.for_run(%{envelope: %{status: "degraded"}}) == :degraded
+    assert ExitClass.for_run(%{partial | results: [timeout]}) == :timed_out
+    assert ExitClass.for_run(%{partial | synthesis: crash}) == :synthesis_failed
+
+    assert ExitClass.for_error(%Error{code: :no_successful_agents, message: "none"}) ==
+             :run_failed
+
+    assert ExitClass.for_error(%Error{code: :review_domain_coverage_missing, message: "gap"}) ==
+             :run_failed
+
+    assert ExitClass.for_error(%Error{code: :run_error, message: "boom"}) == :generic_error
+
+    assert @exit_codes ==
+             %{
+               success: 0,
+               generic_error: 1,
+               degraded: 3,
+               run_failed: 4,
+               synthesis_failed: 5,
+               timed_out: 6,
+               input_error: 7
+             }
+  end
+
   test "prints usage text for help" do
     output =
       capture_io(fn ->
@@ -827,6 +1585,86 @@ defmodule Thinktank.CLITest do
     assert output =~ "Cost: $0.000663"
   end
 
+  test "renders one run-wide token line summed across models" do
+    usage_by_model = %{
+      "anthropic/claude-sonnet-4.6" => %{
+        "input_tokens" => 1_200,
+        "output_tokens" => 300,
+        "cache_read_tokens" => 50,
+        "cache_write_tokens" => 0,
+        "total_tokens" => 1_550
+      },
+      "google/gemini-3-flash-preview" => %{
+        "input_tokens" => 800,
+        "output_tokens" => 700,
+        "cache_read_tokens" => 0,
+        "cache_write_tokens" => 25,
+        "total_tokens" => 1_525
+      }
+    }
+
+    output =
+      CLI.render_run_payload(%{
+        bench: "research/default",
+        status: "complete",
+        output_dir: "/tmp/thinktank-run",
+        agents: [],
+        artifacts: [],
+        usd_cost_total: 0.01,
+        usd_cost_by_model: usage_by_model,
+        pricing_gaps: []
+      })
+
+    assert output =~ "Tokens: input=2000 output=1000 cache_read=50 cache_write=25 total=3075"
+
+    assert RunSummary.usage_total(usage_by_model) == %{
+             "input_tokens" => 2_000,
+             "output_tokens" => 1_000,
+             "cache_read_tokens" => 50,
+             "cache_write_tokens" => 25,
+             "total_tokens" => 3_075
+           }
+
+    assert RunSummary.usage_total(nil)["total_tokens"] == 0
+  end
+
+  test "summary latency counts failed and timed-out agents and per-attempt subprocess time" do
+    agent = fn id, model, metadata ->
+      %{"name" => id, "id" => id, "metadata" => Map.put(metadata, "model", model)}
+    end
+
+    envelope = %{
+      bench: "research/default",
+      status: "degraded",
+      output_dir: "/tmp/thinktank-run",
+      started_at: nil,
+      completed_at: nil,
+      duration_ms: 900,
+      usd_cost_total: nil,
+      agents: [
+        agent.("a-1", "fast", %{"status" => "ok", "duration_ms" => 400}),
+        agent.("a-2", "fast", %{"status" => "error", "error" => %{"category" => "crash"}}),
+        agent.("b-1", "slow", %{"status" => "error", "error" => %{"category" => "timeout"}})
+      ],
+      attempt_durations: %{"a-1" => [100, 250], "a-2" => [30], "b-1" => [800]}
+    }
+
+    latency = RunSummary.build(envelope, :degraded, 3).latency_by_model
+
+    assert latency["fast"] == %{
+             count: 1,
+             min_ms: 400,
+             mean_ms: 400,
+             max_ms: 400,
+             failed: 1,
+             timed_out: 0,
+             attempt_ms: %{count: 3, min_ms: 30, mean_ms: 126, max_ms: 250}
+           }
+
+    assert %{count: 0, min_ms: nil, failed: 0, timed_out: 1, attempt_ms: %{max_ms: 800}} =
+             latency["slow"]
+  end
+
   test "renders pricing gaps in the human-readable run payload" do
     output =
       CLI.render_run_payload(%{
@@ -899,6 +1737,41 @@ defmodule Thinktank.CLITest do
     assert output =~ "review/default\t"
   end
 
+  test "agents list --json reports each agent's provider, model, and pricing" do
+    assert {:ok, %{action: :agents_list, json: true} = command} =
+             CLI.parse_args(["agents", "list", "--json"])
+
+    output = capture_io(fn -> assert CLI.execute({:ok, command}) == 0 end)
+    agents = Jason.decode!(String.trim(output))
+
+    assert %{
+             "provider" => "openrouter",
+             "model" => "anthropic/claude-sonnet-4.6",
+             "pricing" => %{"input" => 3.0, "output" => 15.0}
+           } = Enum.find(agents, &(&1["name"] == "systems"))
+
+    assert agents |> Enum.map(& &1["name"]) |> Enum.sort() == Enum.map(agents, & &1["name"])
+  end
+
+  test "agents list includes agents defined in trusted repo config" do
+    in_tmp_repo_config(
+      """
+      agents:
+        house-model:
+          provider: openrouter
+          model: acme/house-1
+          system_prompt: You are house.
+      """,
+      fn ->
+        {:ok, command} = CLI.parse_args(["agents", "list", "--trust-repo-config"])
+        output = capture_io(fn -> assert CLI.execute({:ok, command}) == 0 end)
+
+        assert output =~ "house-model\topenrouter\tacme/house-1\tunpriced"
+        assert output =~ "systems\topenrouter\tanthropic/claude-sonnet-4.6\t$3.0 in"
+      end
+    )
+  end
+
   test "benches show --full --json resolves agent names to full specs" do
     {:ok, command} =
       CLI.parse_args(["benches", "show", "review/default", "--full", "--json"])
@@ -1,7 +1,7 @@
 defmodule Thinktank.EngineTest do
   use ExUnit.Case, async: false
 
-  alias Thinktank.{Engine, Error, RunTracker}
+  alias Thinktank.{Config, Engine, Error, RunTracker}
 
   defp unique_tmp_dir(prefix) do
     dir = Path.join(System.tmp_dir!(), "#{prefix}-#{System.unique_integer([:positive])}")
@@ -128,6 +128,7 @@ defmodule Thinktank.EngineTest do
                "review/default",
                %{input_text: "Review this branch"},
                cwd: cwd,
+               capture_requests: true,
                runner: runner
              )
 
@@ -162,6 +163,19 @@ defmodule Thinktank.EngineTest do
     assert File.read!(Path.join(result.output_dir, "review.md")) =~
              "## Review Coverage\n\n- Status: degraded"
 
+    assert [failure_file] = Path.wildcard(Path.join(result.output_dir, "failures/*.json"))
+    failure = failure_file |> File.read!() |> Jason.decode!()
+
+    assert failure["agent_name"] == "guard"
+    assert failure["category"] == "crash"
+    assert failure["exit_code"] == 1
+    assert failure["attempts"] == 3
+    assert failure["message"] == "pi exited with status 1: guard failed"
+    assert Enum.map(failure["attempt_errors"], & &1["attempt"]) == [1, 2, 3]
+    refute Map.has_key?(failure, "error")
+    assert failure["request"]["model"] == "x-ai/grok-4.20"
+    assert failure["request"]["prompt_file"] == "prompts/#{failure["instance_id"]}.md"
+
     events = read_jsonl(Path.join(result.output_dir, "trace/events.jsonl"))
 
     assert Enum.any?(events, fn event ->
@@ -319,6 +333,11 @@ defmodule Thinktank.EngineTest do
 
     refute File.exists?(stale_findings_path)
     assert Thinktank.RunStore.result_envelope(output_dir).research_findings == nil
+
+    assert [failure_file] = Path.wildcard(Path.join(output_dir, "failures/*.json"))
+    failure = failure_file |> File.read!() |> Jason.decode!()
+    assert failure["category"] == "crash"
+    refute Map.has_key?(failure, "request")
   end
 
   test "research synthesis writes structured findings alongside the prose synthesis" do
@@ -475,6 +494,100 @@ defmodule Thinktank.EngineTest do
     refute File.exists?(Path.join(result.output_dir, "synthesis.md"))
   end
 
+  test "keeps agent results in bench order regardless of completion order" do
+    cwd = unique_tmp_dir("thinktank-engine-ordering")
+    test_pid = self()
+    delays = %{"systems" => 150, "verification" => 75, "dx" => 0}
+
+    runner = fn _cmd, args, _opts ->
+      path = prompt_path(args)
+      prompt = File.read!(path)
+
+      if String.contains?(prompt, "Agent outputs:") do
+        send(test_pid, {:synthesis_prompt, prompt})
+        {"synthesized", 0}
+      else
+        name = Enum.find(Map.keys(delays), &(Path.basename(path) =~ &1))
+        Process.sleep(Map.fetch!(delays, name))
+        send(test_pid, {:finished, name})
+        {"report from #{name}", 0}
+      end
+    end
+
+    assert {:ok, result} =
+             Engine.run(
+               "research/default",
+               %{input_text: "Research this", agents: ["systems", "verification", "dx"]},
+               cwd: cwd,
+               runner: runner
+             )
+
+    finished =
+      for _ <- 1..3 do
+        assert_receive {:finished, name}
+        name
+      end
+
+    assert finished == ["dx", "verification", "systems"]
+
+    expected = ["systems", "verification", "dx"]
+    assert Enum.map(result.results, & &1.agent.name) == expected
+
+    assert result.envelope.agents |> Enum.map(& &1["name"]) |> Enum.take(3) == expected
+
+    assert_receive {:synthesis_prompt, prompt}
+    offsets = Enum.map(expected, &(:binary.match(prompt, "## #{&1}") |> elem(0)))
+    assert offsets == Enum.sort(offsets)
+  end
+
+  test "reruns an agent once on its fallback model after the primary fails" do
+    cwd = unique_tmp_dir("thinktank-engine-fallback")
+    test_pid = self()
+    {:ok, config} = Config.load(cwd: cwd)
+
+    config =
+      update_in(config.agents["systems"], fn agent ->
+        %{agent | model: "example/primary", fallback_model: "example/fallback"}
+      end)
+
+    runner = fn _cmd, args, _opts ->
+      model = Enum.at(args, Enum.find_index(args, &(&1 == "--model")) + 1)
+      send(test_pid, {:launched, model})
+
+      case model do
+        "example/primary" -> {"content filtered", 1}
+        _ -> {"fallback report", 0}
+      end
+    end
+
+    assert {:ok, result} =
+             Engine.run(
+               "research/default",
+               %{input_text: "Research this", agents: ["systems", "dx"], no_synthesis: true},
+               cwd: cwd,
+               config: config,
+               runner: runner
+             )
+
+    assert result.envelope.status == "complete"
+    assert [systems, _dx] = result.results
+    assert systems.status == :ok
+    assert systems.agent.model == "example/fallback"
+    assert systems.output =~ "fallback report"
+
+    assert_receive {:launched, "example/fallback"}
+    refute_receive {:launched, "example/fallback"}
+
+    [primary, fallback | _] = result.envelope.agents
+    assert primary["metadata"]["status"] == "error"
+    assert primary["metadata"]["model"] == "example/primary"
+    assert fallback["name"] == "systems"
+    assert fallback["metadata"]["model"] == "example/fallback"
+    assert fallback["metadata"]["fallback_for"] == primary["id"]
+    refute fallback["id"] == primary["id"]
+    assert Path.wildcard(Path.join(result.output_dir, "failures/*.json")) == []
+  end
+
   test "preserves separate artifacts when the same agent runs twice" do
     cwd = unique_tmp_dir("thinktank-engine-duplicate-agents")
 
@@ -1414,4 +1527,101 @@ defmodule Thinktank.EngineTest do
                trust_repo_config: true
              )
   end
+
+  test "output_transform rewrites recorded outputs and keeps the raw output in a sidecar" do
+    cwd = unique_tmp_dir("thinktank-engine-output-transform")
+    init_git_repo_with_commit!(cwd)
+    raw = "Some prose.\n```\nthe answer\n```\n"
+    runner = fn _cmd, _args, _opts -> {raw, 0} end
+
+    transform = fn
+      %{name: "systems"}, output ->
+        [_, block] = Regex.run(~r/```\n(.*?)```/s, output)
+        {:ok, block}
+
+      _agent, _output ->
+        {:error, "no fenced block"}
+    end
+
+    assert {:ok, result} =
+             Engine.run(
+               "research/default",
+               %{input_text: "Research this", agents: ["systems", "dx"], no_synthesis: true},
+               cwd: cwd,
+               runner: runner,
+               output_transform: transform
+             )
+
+    assert result.envelope.status == "degraded"
+    results = Map.new(result.results, &{&1.agent.name, &1})
+    systems = results["systems"]
+    dx = results["dx"]
+    agent_file = &Path.join(result.output_dir, "agents/#{&1.instance_id}.md")
+    raw_file = &Path.join(result.output_dir, "agents/#{&1.instance_id}.raw.md")
+
+    assert systems.status == :ok
+    assert File.read!(agent_file.(systems)) == "the answer\n"
+    assert File.read!(raw_file.(systems)) == raw
+
+    assert dx.status == :error
+    assert dx.error.category == :output_transform
+    assert dx.error.message == "output transform failed: no fenced block"
+    assert File.read!(agent_file.(dx)) =~ raw
+    assert File.read!(raw_file.(dx)) == raw
+  end
+
+  describe "model policy" do
+    setup do
+      names = ["THINKTANK_ALLOWED_MODELS", "THINKTANK_DENIED_MODELS"]
+      previous = Map.new(names, &{&1, System.get_env(&1)})
+
+      on_exit(fn ->
+        Enum.each(previous, fn
+          {name, nil} -> System.delete_env(name)
+          {name, value} -> System.put_env(name, value)
+        end)
+      end)
+
+      Enum.each(names, &System.delete_env/1)
+      %{cwd: unique_tmp_dir("thinktank-engine-model-policy")}
+    end
+
+    test "rejects agents whose model is outside THINKTANK_ALLOWED_MODELS", %{cwd: cwd} do
+      System.put_env("THINKTANK_ALLOWED_MODELS", "anthropic/*")
+
+      assert {:ok, _resolved} =
+               Engine.resolve(
+                 "research/default",
+                 %{input_text: "Research this", agents: ["systems"], no_synthesis: true},
+                 cwd: cwd
+               )
+
+      assert {:error, %Error{code: :model_policy_violation} = error, nil} =
+               Engine.resolve(
+                 "research/default",
+                 %{input_text: "Research this", agents: ["systems", "dx"], no_synthesis: true},
+                 cwd: cwd
+               )
+
+      assert error.message =~ "dx ("
+      assert error.message =~ "not in THINKTANK_ALLOWED_MODELS"
+      refute error.message =~ "systems ("
+    end
+
+    test "rejects agents whose model is in THINKTANK_DENIED_MODELS", %{cwd: cwd} do
+      {:ok, config} = Config.load(cwd: cwd)
+      System.put_env("THINKTANK_ALLOWED_MODELS", "*")
+      System.put_env("THINKTANK_DENIED_MODELS", config.agents["systems"].model)
+
+      assert {:error, %Error{code: :model_policy_violation} = error, nil} =
+               Engine.resolve(
+                 "research/default",
+                 %{input_text: "Research this", agents: ["systems"], no_synthesis: true},
+                 cwd: cwd
+               )
+
+      assert [%{agent: "systems", reason: "in THINKTANK_DENIED_MODELS"}] =
+               error.details.violations
+    end
+  end
 end
@@ -0,0 +1,32 @@
+defmodule Thinktank.EnvFileTest do
+  use ExUnit.Case, async: true
+
+  alias Thinktank.EnvFile
+
+  test "parses assignments, comments, export prefixes, and quoted values" do
+    body = """
+    # comment
+    PLAIN=value
+    export EXPORTED=yes
+    DOUBLE="spaced value" # trailing
+    SINGLE='single quoted'
+    INLINE=bare # trailing comment
+    EMPTY=
+    not a line
+    1BAD=skip
+    """
+
+    assert EnvFile.parse(body) == [
+             {"PLAIN", "value"},
+             {"EXPORTED", "yes"},
+             {"DOUBLE", "spaced value"},
+             {"SINGLE", "single quoted"},
+             {"INLINE", "bare"},
+             {"EMPTY", ""}
+           ]
+  end
+
+  test "keeps hash characters inside unquoted values" do
+    assert EnvFile.parse("URL=http://host/#anchor\n") == [{"URL", "http://host/#anchor"}]
+  end
+end
@@ -2,7 +2,7 @@ defmodule Thinktank.Executor.AgenticTest do
   use ExUnit.Case, async: false
   import ExUnit.CaptureLog
 
-  alias Thinktank.{AgentSpec, Config, ProviderSpec, RunContract}
+  alias Thinktank.{AgentSpec, Config, ProviderSpec, Redaction, RunContract}
   alias Thinktank.Executor.Agentic
 
   defp unique_tmp_dir(prefix) do
@@ -19,7 +19,7 @@ defmodule Thinktank.Executor.AgenticTest do
     |> Enum.map(&Jason.decode!/1)
   end
 
-  defp write_session_usage(pi_home, session_name, usage) do
+  defp write_session_usage(pi_home, session_name, usage, message \\ %{}) do
     path = Path.join([pi_home, "sessions", "2026", "#{session_name}.jsonl"])
     File.mkdir_p!(Path.dirname(path))
 
@@ -27,7 +27,7 @@ defmodule Thinktank.Executor.AgenticTest do
       path,
       Jason.encode!(%{
         "type" => "message",
-        "message" => %{"role" => "assistant", "usage" => usage}
+        "message" => Map.merge(%{"role" => "assistant", "usage" => usage}, message)
       }) <> "\n"
     )
   end
@@ -337,6 +337,419 @@ defmodule Thinktank.Executor.AgenticTest do
     assert_receive {:openrouter_key, "fallback-secret"}
   end
 
+  test "launches native providers with their own pi provider and key variable" do
+    tmp = unique_tmp_dir("thinktank-agentic-native-provider")
+    test_pid = self()
+
+    config = %{
+      config()
+      | providers: %{
+          "google" => %ProviderSpec{
+            id: "google",
+            adapter: :google,
+            credential_env: "THINKTANK_GEMINI_API_KEY",
+            defaults: %{}
+          }
+        }
+    }
+
+    System.put_env("THINKTANK_GEMINI_API_KEY", "gemini-secret")
+    on_exit(fn -> System.delete_env("THINKTANK_GEMINI_API_KEY") end)
+
+    agent = %AgentSpec{
+      name: "trace",
+      provider: "google",
+      model: "gemini-2.5-pro",
+      system_prompt: "You are a reviewer.",
+      thinking_level: "high",
+      task_prompt: "{{input_text}}",
+      timeout_ms: 5_000
+    }
+
+    runner = fn _cmd, args, opts ->
+      env = opts |> Keyword.fetch!(:env) |> Enum.into(%{})
+      provider = Enum.at(args, Enum.find_index(args, &(&1 == "--provider")) + 1)
+      send(test_pid, {:launch, provider, env})
+      {"ok", 0}
+    end
+
+    [result] = Agentic.run([agent], contract(tmp), %{}, config, runner: runner)
+
+    assert result.status == :ok
+    assert_receive {:launch, "google", env}
+    assert env["GEMINI_API_KEY"] == "gemini-secret"
+    refute Map.has_key?(env, "OPENROUTER_API_KEY")
+  end
+
+  test "registers keyless ollama endpoints as a pi custom provider" do
+    tmp = unique_tmp_dir("thinktank-agentic-ollama")
+    test_pid = self()
+
+    config = %{
+      config()
+      | providers: %{
+          "local" => %ProviderSpec{
+            id: "local",
+            adapter: :ollama,
+            credential_env: nil,
+            defaults: %{"endpoint" => "http://127.0.0.1:11500/v1"}
+          }
+        }
+    }
+
+    agent = %AgentSpec{
+      name: "trace",
+      provider: "local",
+      model: "llama3.1:8b",
+      system_prompt: "You are a reviewer.",
+      thinking_level: "high",
+      task_prompt: "{{input_text}}",
+      timeout_ms: 5_000
+    }
+
+    runner = fn _cmd, args, opts ->
+      env = opts |> Keyword.fetch!(:env) |> Enum.into(%{})
+      models = env |> Map.fetch!("PI_CODING_AGENT_DIR") |> Path.join("models.json")
+      send(test_pid, {:launch, args, env, models |> File.read!() |> Jason.decode!()})
+      {"ok", 0}
+    end
+
+    [result] = Agentic.run([agent], contract(tmp), %{}, config, runner: runner)
+
+    assert result.status == :ok
+    assert_receive {:launch, args, env, models}
+    assert ["--provider", "ollama", "--model", "llama3.1:8b"] -- args == []
+    refute Map.has_key?(env, "OPENROUTER_API_KEY")
+    assert models["providers"]["ollama"]["baseUrl"] == "http://127.0.0.1:11500/v1"
+    assert [%{"id" => "llama3.1:8b"}] = models["providers"]["ollama"]["models"]
+  end
+
+  test "routes providers on the same adapter to their own endpoints and keys" do
+    tmp = unique_tmp_dir("thinktank-agentic-gateways")
+    test_pid = self()
+
+    System.put_env("GATEWAY_A_KEY", "key-a")
+    System.put_env("GATEWAY_B_KEY", "key-b")
+
+    on_exit(fn ->
+      System.delete_env("GATEWAY_A_KEY")
+      System.delete_env("GATEWAY_B_KEY")
+    end)
+
+    gateway = fn id, env, endpoint ->
+      %ProviderSpec{
+        id: id,
+        adapter: :openai,
+        credential_env: env,
+        defaults: %{"endpoint" => endpoint}
+      }
+    end
+
+    config = %{
+      config()
+      | providers: %{
+          "gateway-a" => gateway.("gateway-a", "GATEWAY_A_KEY", "https://a.example/v1"),
+          "gateway-b" => gateway.("gateway-b", "GATEWAY_B_KEY", "https://b.example/v1")
+        }
+    }
+
+    agent = fn name, provider, model ->
+      %AgentSpec{
+        name: name,
+        provider: provider,
+        model: model,
+        system_prompt: "You are a reviewer.",
+        thinking_level: "high",
+        task_prompt: "{{input_text}}",
+        timeout_ms: 5_000
+      }
+    end
+
+    runner = fn _cmd, args, opts ->
+      env = opts |> Keyword.fetch!(:env) |> Enum.into(%{})
+      model = Enum.at(args, Enum.find_index(args, &(&1 == "--model")) + 1)
+      models = env |> Map.fetch!("PI_CODING_AGENT_DIR") |> Path.join("models.json")
+      send(test_pid, {:launch, model, env, models |> File.read!() |> Jason.decode!()})
+      {"ok", 0}
+    end
+
+    agents = [
+      agent.("trace", "gateway-a", "gpt-a"),
+      agent.("guard", "gateway-b", "gpt-b")
+    ]
+
+    results = Agentic.run(agents, contract(tmp), %{}, config, runner: runner)
+
+    assert Enum.all?(results, &(&1.status == :ok))
+    assert_receive {:launch, "gpt-a", env_a, models_a}
+    assert_receive {:launch, "gpt-b", env_b, models_b}
+    assert env_a["OPENAI_API_KEY"] == "key-a"
+    assert env_b["OPENAI_API_KEY"] == "key-b"
+    assert models_a["providers"]["openai"] == %{"baseUrl" => "https://a.example/v1"}
+    assert models_b["providers"]["openai"] == %{"baseUrl" => "https://b.example/v1"}
+  end
+
+  test "passes --attach images only to vision agents" do
+    tmp = unique_tmp_dir("thinktank-agentic-attach")
+    test_pid = self()
+    image = Path.join(tmp, "screen.png")
+    File.write!(image, "png")
+
+    agent = fn name, vision ->
+      %AgentSpec{
+        name: name,
+        provider: "openrouter",
+        model: "openai/gpt-5.4",
+        system_prompt: "You are a reviewer.",
+        thinking_level: "high",
+        task_prompt: "{{input_text}}",
+        timeout_ms: 5_000,
+        vision: vision
+      }
+    end
+
+    runner = fn _cmd, args, opts ->
+      home = opts |> Keyword.fetch!(:env) |> Map.new() |> Map.fetch!("PI_CODING_AGENT_DIR")
+      send(test_pid, {:launch, Path.basename(home), args})
+      {"ok", 0}
+    end
+
+    contract = %{contract(tmp) | input: %{"input_text" => "Review", "attachments" => [image]}}
+
+    [_, _] =
+      Agentic.run([agent.("trace", true), agent.("guard", false)], contract, %{}, config(),
+        runner: runner
+      )
+
+    assert_receive {:launch, "trace" <> _, trace_args}
+    assert_receive {:launch, "guard" <> _, guard_args}
+    assert List.last(trace_args) == "@#{image}"
+    refute "@#{image}" in guard_args
+  end
+
+  test "moves to the next credential in a comma-separated key list on every retry" do
+    tmp = unique_tmp_dir("thinktank-agentic-key-rotation")
+    test_pid = self()
+    counter = :atomics.new(1, [])
+
+    System.put_env("THINKTANK_OPENROUTER_API_KEY", "key-a, key-b")
+    on_exit(fn -> System.delete_env("THINKTANK_OPENROUTER_API_KEY") end)
+
+    agent = %AgentSpec{
+      name: "trace",
+      provider: "openrouter",
+      model: "openai/gpt-5.4",
+      system_prompt: "You are a reviewer.",
+      thinking_level: "high",
+      task_prompt: "{{input_text}}",
+      timeout_ms: 5_000,
+      retries: 1
+    }
+
+    runner = fn _cmd, _args, opts ->
+      env = opts |> Keyword.fetch!(:env) |> Enum.into(%{})
+      send(test_pid, {:openrouter_key, env["OPENROUTER_API_KEY"]})
+
+      case :atomics.add_get(counter, 1, 1) do
+        1 -> {"transient failure", 1}
+        _ -> {"ok", 0}
+      end
+    end
+
+    contract = contract(tmp)
+    [result] = Agentic.run([agent], contract, %{}, config(), runner: runner)
+
+    assert result.status == :ok
+    assert_receive {:openrouter_key, "key-a"}
+    assert_receive {:openrouter_key, "key-b"}
+
+    events = read_jsonl(Path.join(contract.artifact_dir, "trace/events.jsonl"))
+
+    assert events
+           |> Enum.filter(&(&1["event"] == "subprocess_started"))
+           |> Enum.map(& &1["credential_slot"]) == [0, 1]
+
+    refute File.read!(Path.join(contract.artifact_dir, "trace/events.jsonl")) =~ "key-b"
+  end
+
+  test "forwards output chunks to the progress callback as they arrive" do
+    tmp = unique_tmp_dir("thinktank-agentic-stream")
+    test_pid = self()
+
+    agent = %AgentSpec{
+      name: "trace",
+      provider: "openrouter",
+      model: "openai/gpt-5.4",
+      system_prompt: "You are a reviewer.",
+      thinking_level: "high",
+      task_prompt: "{{input_text}}",
+      timeout_ms: 5_000
+    }
+
+    runner = fn _cmd, _args, opts ->
+      sink = Keyword.fetch!(opts, :output_sink)
+      sink.("first chunk\n")
+      sink.("second chunk\n")
+      {"first chunk\nsecond chunk\n", 0}
+    end
+
+    progress_callback = fn event, attrs -> send(test_pid, {:progress, event, attrs}) end
+    contract = contract(tmp)
+
+    [result] =
+      Agentic.run([agent], contract, %{}, config(),
+        runner: runner,
+        progress_callback: progress_callback
+      )
+
+    assert result.status == :ok
+    assert_receive {:progress, "agent_output",
+                    %{"agent_name" => "trace", "chunk" => "first chunk\n"}}
+    assert_receive {:progress, "agent_output", %{"chunk" => "second chunk\n"}}
+
+    [stream_file] = Path.wildcard(Path.join(contract.artifact_dir, "artifacts/streams/*.txt"))
+    assert File.read!(stream_file) == "first chunk\nsecond chunk\n"
+  end
+
+  test "masks credential values the agent echoes in its stream, result, and progress chunks" do
+    tmp = unique_tmp_dir("thinktank-agentic-redact-output")
+    test_pid = self()
+    System.put_env("THINKTANK_OPENROUTER_API_KEY", "echoed-secret-value")
+    Redaction.refresh_secrets()
+
+    on_exit(fn ->
+      System.delete_env("THINKTANK_OPENROUTER_API_KEY")
+      Redaction.refresh_secrets()
+    end)
+
+    agent = %AgentSpec{
+      name: "trace",
+      provider: "openrouter",
+      model: "openai/gpt-5.4",
+      system_prompt: "You are a reviewer.",
+      thinking_level: "high",
+      task_prompt: "{{input_text}}",
+      timeout_ms: 5_000
+    }
+
+    runner = fn _cmd, _args, opts ->
+      Keyword.fetch!(opts, :output_sink).("auth: echoed-secret-value\n")
+      {"auth: echoed-secret-value\n", 0}
+    end
+
+    progress_callback = fn event, attrs -> send(test_pid, {:progress, event, attrs}) end
+    contract = contract(tmp)
+
+    [result] =
+      Agentic.run([agent], contract, %{}, config(),
+        runner: runner,
+        progress_callback: progress_callback
+      )
+
+    assert result.output == "auth: ***\n"
+    assert_receive {:progress, "agent_output", %{"chunk" => "auth: ***\n"}}
+
+    [stream_file] = Path.wildcard(Path.join(contract.artifact_dir, "artifacts/streams/*.txt"))
+    assert File.read!(stream_file) == "auth: ***\n"
+  end
+
+  test "masks a credential split across two output chunks" do
+    tmp = unique_tmp_dir("thinktank-agentic-redact-split")
+    test_pid = self()
+    System.put_env("THINKTANK_OPENROUTER_API_KEY", "echoed-secret-value")
+    Redaction.refresh_secrets()
+
+    on_exit(fn ->
+      System.delete_env("THINKTANK_OPENROUTER_API_KEY")
+      Redaction.refresh_secrets()
+    end)
+
+    agent = %AgentSpec{
+      name: "trace",
+      provider: "openrouter",
+      model: "openai/gpt-5.4",
+      system_prompt: "You are a reviewer.",
+      thinking_level: "high",
+      task_prompt: "{{input_text}}",
+      timeout_ms: 5_000
+    }
+
+    runner = fn _cmd, _args, opts ->
+      sink = Keyword.fetch!(opts, :output_sink)
+      sink.("ok\nauth: echoed-sec")
+      sink.("ret-value\ndone")
+      {"ok\nauth: echoed-secret-value\ndone", 0}
+    end
+
+    progress_callback = fn event, attrs -> send(test_pid, {:progress, event, attrs}) end
+    contract = contract(tmp)
+
+    Agentic.run([agent], contract, %{}, config(),
+      runner: runner,
+      progress_callback: progress_callback
+    )
+
+    assert_receive {:progress, "agent_output", %{"chunk" => "ok\n"}}
+    assert_receive {:progress, "agent_output", %{"chunk" => "auth: ***\n"}}
+    assert_receive {:progress, "agent_output", %{"chunk" => "done"}}
+
+    [stream_file] = Path.wildcard(Path.join(contract.artifact_dir, "artifacts/streams/*.txt"))
+    assert File.read!(stream_file) == "ok\nauth: ***\ndone"
+  end
+
+  test "a retry after partial output keeps only the clean attempt as the result" do
+    tmp = unique_tmp_dir("thinktank-agentic-stream-retry")
+    counter = :atomics.new(1, [])
+
+    agent = %AgentSpec{
+      name: "trace",
+      provider: "openrouter",
+      model: "openai/gpt-5.4",
+      system_prompt: "You are a reviewer.",
+      thinking_level: "high",
+      task_prompt: "{{input_text}}",
+      timeout_ms: 5_000,
+      retries: 1,
+      retry_delay_ms: 1
+    }
+
+    runner = fn _cmd, _args, opts ->
+      sink = Keyword.fetch!(opts, :output_sink)
+
+      case :atomics.add_get(counter, 1, 1) do
+        1 ->
+          sink.("half an ans")
+          {"half an ans", 1}
+
+        _ ->
+          sink.("full answer\n")
+          {"full answer\n", 0}
+      end
+    end
+
+    test_pid = self()
+    progress_callback = fn event, attrs -> send(test_pid, {:progress, event, attrs}) end
+    contract = contract(tmp)
+
+    [result] =
+      Agentic.run([agent], contract, %{}, config(),
+        runner: runner,
+        progress_callback: progress_callback
+      )
+
+    assert result.status == :ok
+    assert result.output == "full answer\n"
+
+    assert_receive {:progress, "agent_retrying",
+                    %{"agent_name" => "trace", "attempt" => 2, "max_attempts" => 2}}
+
+    [stream_file] = Path.wildcard(Path.join(contract.artifact_dir, "artifacts/streams/*.txt"))
+
+    assert File.read!(stream_file) ==
+             "half an ans\n--- attempt 2: restarting; output above was discarded ---\n" <>
+               "full answer\n"
+  end
+
   test "renders agent metadata into the prompt context" do
     tmp = unique_tmp_dir("thinktank-agentic-metadata")
     test_pid = self()
@@ -372,6 +785,77 @@ defmodule Thinktank.Executor.AgenticTest do
     assert prompt =~ "Brief=Focus on regressions."
   end
 
+  test "applies a prompt_transform to the prompt the agent receives" do
+    tmp = unique_tmp_dir("thinktank-agentic-prompt-transform")
+    test_pid = self()
+
+    agent = %AgentSpec{
+      name: "trace",
+      provider: "openrouter",
+      model: "openai/gpt-5.4",
+      system_prompt: "You are a reviewer.",
+      thinking_level: "high",
+      task_prompt: "{{input_text}}",
+      timeout_ms: 5_000
+    }
+
+    runner = fn _cmd, args, _opts ->
+      prompt =
+        args
+        |> Enum.drop_while(&(&1 != "-p"))
+        |> Enum.at(1)
+        |> String.trim_leading("@")
+        |> File.read!()
+
+      send(test_pid, {:prompt, prompt})
+      {"ok", 0}
+    end
+
+    transform = fn %AgentSpec{name: "trace"}, prompt -> {:ok, String.upcase(prompt)} end
+
+    [result] =
+      Agentic.run([agent], contract(tmp), %{}, config(),
+        runner: runner,
+        prompt_transform: transform
+      )
+
+    assert result.status == :ok
+    assert_receive {:prompt, prompt}
+    assert prompt =~ "YOU ARE A REVIEWER."
+    refute prompt =~ "You are a reviewer."
+  end
+
+  test "a failing prompt_transform aborts the agent before launch" do
+    tmp = unique_tmp_dir("thinktank-agentic-prompt-transform-error")
+    test_pid = self()
+
+    agent = %AgentSpec{
+      name: "trace",
+      provider: "openrouter",
+      model: "openai/gpt-5.4",
+      system_prompt: "You are a reviewer.",
+      thinking_level: "high",
+      task_prompt: "{{input_text}}",
+      timeout_ms: 5_000
+    }
+
+    runner = fn _cmd, _args, _opts ->
+      send(test_pid, :launched)
+      {"ok", 0}
+    end
+
+    [result] =
+      Agentic.run([agent], contract(tmp), %{}, config(),
+        runner: runner,
+        prompt_transform: fn _agent, _prompt -> {:error, "license header not found"} end
+      )
+
+    assert result.status == :error
+    assert result.error.category == :prompt_transform
+    assert result.error.message == "prompt transform failed: license header not found"
+    refute_received :launched
+  end
+
   test "writes durable trace events and mirrors them to the configured global log" do
     tmp = unique_tmp_dir("thinktank-agentic-trace")
     log_dir = unique_tmp_dir("thinktank-agentic-logs")
@@ -450,6 +934,219 @@ defmodule Thinktank.Executor.AgenticTest do
     refute File.read!(global_log) =~ "super-secret-value"
   end
 
+  test "grows retry waits exponentially up to the configured ceiling" do
+    tmp = unique_tmp_dir("thinktank-agentic-backoff")
+
+    agent = %AgentSpec{
+      name: "trace",
+      provider: "openrouter",
+      model: "openai/gpt-5.4",
+      system_prompt: "You are a reviewer.",
+      thinking_level: "high",
+      task_prompt: "{{input_text}}",
+      timeout_ms: 5_000,
+      retries: 3,
+      retry_delay_ms: 2,
+      retry_multiplier: 3,
+      retry_max_delay_ms: 10
+    }
+
+    runner = fn _cmd, _args, _opts -> {"still failing", 1} end
+    contract = contract(tmp)
+
+    [result] = Agentic.run([agent], contract, %{}, config(), runner: runner)
+
+    assert result.status == :error
+
+    delays =
+      contract.artifact_dir
+      |> Path.join("trace/events.jsonl")
+      |> read_jsonl()
+      |> Enum.filter(&(&1["event"] == "attempt_retry_scheduled"))
+      |> Enum.map(& &1["delay_ms"])
+
+    assert delays == [2, 6, 10]
+  end
+
+  test "retries blank output and fails with empty_output when it persists" do
+    tmp = unique_tmp_dir("thinktank-agentic-empty-output")
+    counter = :atomics.new(1, [])
+
+    agent = %AgentSpec{
+      name: "trace",
+      provider: "openrouter",
+      model: "openai/gpt-5.4",
+      system_prompt: "You are a reviewer.",
+      thinking_level: "high",
+      task_prompt: "{{input_text}}",
+      timeout_ms: 5_000,
+      retries: 2,
+      retry_delay_ms: 0
+    }
+
+    runner = fn _cmd, _args, _opts ->
+      case :atomics.add_get(counter, 1, 1) do
+        1 -> {"", 0}
+        2 -> {"  \n", 0}
+        _ -> {"third attempt answer", 0}
+      end
+    end
+
+    [result] = Agentic.run([agent], contract(tmp), %{}, config(), runner: runner)
+
+    assert result.status == :ok
+    assert result.output == "third attempt answer"
+    assert result.attempts == 3
+
+    blank = fn _cmd, _args, _opts -> {"\n", 0} end
+    tmp = unique_tmp_dir("thinktank-agentic-empty-output-persist")
+
+    [result] =
+      Agentic.run([%{agent | retries: 1}], contract(tmp), %{}, config(), runner: blank)
+
+    assert result.status == :error
+    assert result.error.category == :empty_output
+    assert result.error.message == "agent produced no output"
+  end
+
+  test "an exhausted agent error carries one history entry per attempt" do
+    tmp = unique_tmp_dir("thinktank-agentic-attempt-history")
+    counter = :atomics.new(1, [])
+
+    agent = %AgentSpec{
+      name: "trace",
+      provider: "openrouter",
+      model: "openai/gpt-5.4",
+      system_prompt: "You are a reviewer.",
+      thinking_level: "high",
+      task_prompt: "{{input_text}}",
+      timeout_ms: 5_000,
+      retries: 2,
+      retry_delay_ms: 0
+    }
+
+    runner = fn _cmd, _args, _opts ->
+      case :atomics.add_get(counter, 1, 1) do
+        2 -> {"", 0}
+        _ -> {"starting\nprovider rejected the request\n", 7}
+      end
+    end
+
+    [result] = Agentic.run([agent], contract(tmp), %{}, config(), runner: runner)
+
+    assert result.status == :error
+    assert result.error.category == :crash
+    assert length(result.error.attempt_errors) == result.error.attempts
+
+    assert [
+             %{
+               attempt: 1,
+               category: :crash,
+               exit_code: 7,
+               message: "pi exited with status 7: provider rejected the request"
+             },
+             %{attempt: 2, category: :empty_output, message: "agent produced no output"},
+             %{attempt: 3, category: :crash, exit_code: 7}
+           ] = result.error.attempt_errors
+  end
+
+  test "allow_empty_output accepts a clean exit with blank output" do
+    tmp = unique_tmp_dir("thinktank-agentic-allow-empty")
+
+    agent = %AgentSpec{
+      name: "trace",
+      provider: "openrouter",
+      model: "openai/gpt-5.4",
+      system_prompt: "You are a reviewer.",
+      thinking_level: "high",
+      task_prompt: "{{input_text}}",
+      timeout_ms: 5_000
+    }
+
+    runner = fn _cmd, _args, _opts -> {"", 0} end
+
+    [result] =
+      Agentic.run([agent], contract(tmp), %{}, config(),
+        runner: runner,
+        allow_empty_output: true
+      )
+
+    assert result.status == :ok
+    assert result.output == ""
+  end
+
+  test "a retry wait longer than the per-attempt timeout does not abort the agent" do
+    tmp = unique_tmp_dir("thinktank-agentic-long-backoff")
+    counter = :atomics.new(1, [])
+    test_pid = self()
+
+    # The task budget must cover the retry wait on top of both attempts; the
+    # injected sleep records the wait instead of taking it.
+    agent = %AgentSpec{
+      name: "trace",
+      provider: "openrouter",
+      model: "openai/gpt-5.4",
+      system_prompt: "You are a reviewer.",
+      thinking_level: "high",
+      task_prompt: "{{input_text}}",
+      timeout_ms: 50,
+      retries: 1,
+      retry_delay_ms: 5_500
+    }
+
+    runner = fn _cmd, _args, _opts ->
+      case :atomics.add_get(counter, 1, 1) do
+        1 -> {"transient failure", 1}
+        _ -> {"recovered", 0}
+      end
+    end
+
+    assert Agentic.task_budget_ms([agent], task_slack_ms: 0) == 50 * 2 + 5_500
+    assert Agentic.task_budget_ms([agent]) == 50 * 2 + 5_500 + 5_000
+
+    [result] =
+      Agentic.run([agent], contract(tmp), %{}, config(),
+        runner: runner,
+        task_slack_ms: 0,
+        sleep: &send(test_pid, {:slept, &1})
+      )
+
+    assert result.status == :ok
+    assert result.attempts == 2
+    assert result.output =~ "recovered"
+    assert_received {:slept, 5_500}
+  end
+
+  test "each attempt gets the per-attempt timeout regardless of retry waits" do
+    tmp = unique_tmp_dir("thinktank-agentic-attempt-timeout")
+    test_pid = self()
+
+    agent = %AgentSpec{
+      name: "trace",
+      provider: "openrouter",
+      model: "openai/gpt-5.4",
+      system_prompt: "You are a reviewer.",
+      thinking_level: "high",
+      task_prompt: "{{input_text}}",
+      timeout_ms: 250,
+      retries: 2,
+      retry_delay_ms: 5,
+      retry_max_delay_ms: 5
+    }
+
+    runner = fn _cmd, _args, opts ->
+      send(test_pid, {:attempt_timeout, Keyword.fetch!(opts, :timeout)})
+      {"still failing", 1}
+    end
+
+    [result] = Agentic.run([agent], contract(tmp), %{}, config(), runner: runner)
+
+    assert result.status == :error
+    assert result.attempts == 3
+
+    for _ <- 1..3, do: assert_receive({:attempt_timeout, 250})
+  end
+
   test "aggregates session usage across retries into the final result" do
     tmp = unique_tmp_dir("thinktank-agentic-usage")
     counter = :atomics.new(1, [])
@@ -493,6 +1190,38 @@ defmodule Thinktank.Executor.AgenticTest do
     assert result.usage["total_tokens"] == 390
     assert result.usage["pricing_gap"] == nil
     assert_in_delta result.usage["usd_cost"], 0.0003645, 1.0e-12
+    refute result.truncated
+  end
+
+  test "flags output cut off at the model's output token limit" do
+    tmp = unique_tmp_dir("thinktank-agentic-truncated")
+
+    agent = %AgentSpec{
+      name: "trace",
+      provider: "openrouter",
+      model: "openai/gpt-5.4-mini",
+      system_prompt: "You are a reviewer.",
+      thinking_level: "high",
+      task_prompt: "{{input_text}}",
+      timeout_ms: 5_000
+    }
+
+    runner = fn _cmd, _args, opts ->
+      env = opts |> Keyword.fetch!(:env) |> Enum.into(%{})
+      pi_home = Map.fetch!(env, "PI_CODING_AGENT_DIR")
+      write_session_usage(pi_home, "session", %{"output" => 4096}, %{"stopReason" => "length"})
+      {"The first half of an answ", 0}
+    end
+
+    contract = contract(tmp)
+    [result] = Agentic.run([agent], contract, %{}, config(), runner: runner)
+
+    assert result.status == :ok
+    assert result.truncated
+    assert result.output == "The first half of an answ"
+
+    scratchpad = Path.join(contract.artifact_dir, "scratchpads/#{result.instance_id}.md")
+    assert File.read!(scratchpad) =~ "likely truncated"
   end
 
   test "timeout subprocess traces use a nil exit_code" do
@@ -515,6 +1244,9 @@ defmodule Thinktank.Executor.AgenticTest do
 
     assert result.status == :error
 
+    assert [%{category: :timeout, message: "pi timed out after 5000 ms"}] =
+             result.error.attempt_errors
+
     events = read_jsonl(Path.join(contract.artifact_dir, "trace/events.jsonl"))
 
     assert Enum.any?(events, fn event ->
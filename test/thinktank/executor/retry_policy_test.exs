@@ -0,0 +1,80 @@
+defmodule Thinktank.Executor.RetryPolicyTest do
+  use ExUnit.Case, async: false
+
+  alias Thinktank.AgentSpec
+  alias Thinktank.Executor.RetryPolicy
+
+  defp agent(overrides \\ []) do
+    struct!(
+      %AgentSpec{
+        name: "retry",
+        provider: "openrouter",
+        model: "demo/model",
+        system_prompt: "You are retry.",
+        thinking_level: "high",
+        task_prompt: "{{input_text}}"
+      },
+      overrides
+    )
+  end
+
+  test "grows waits by the multiplier on each attempt" do
+    agent = agent(retry_delay_ms: 100, retry_multiplier: 3, retry_max_delay_ms: 10_000)
+
+    assert Enum.map(1..4, &RetryPolicy.delay_ms(agent, &1)) == [100, 300, 900, 2_700]
+  end
+
+  test "caps waits at the agent ceiling" do
+    agent = agent(retry_delay_ms: 250, retry_multiplier: 2, retry_max_delay_ms: 600)
+
+    assert Enum.map(1..4, &RetryPolicy.delay_ms(agent, &1)) == [250, 500, 600, 600]
+  end
+
+  test "keeps a flat wait when the multiplier is 1" do
+    agent = agent(retry_delay_ms: 40, retry_multiplier: 1, retry_max_delay_ms: 1_000)
+
+    assert Enum.map(1..3, &RetryPolicy.delay_ms(agent, &1)) == [40, 40, 40]
+  end
+
+  test "rounds fractional growth to whole milliseconds" do
+    agent = agent(retry_delay_ms: 10, retry_multiplier: 1.5, retry_max_delay_ms: 1_000)
+
+    assert Enum.map(1..3, &RetryPolicy.delay_ms(agent, &1)) == [10, 15, 23]
+  end
+
+  test "sums only the waits between attempts" do
+    agent = agent(retry_delay_ms: 100, retry_multiplier: 2, retry_max_delay_ms: 250)
+
+    assert RetryPolicy.total_delay_ms(agent, 0) == 0
+    assert RetryPolicy.total_delay_ms(agent, 1) == 0
+    assert RetryPolicy.total_delay_ms(agent, 2) == 100
+    assert RetryPolicy.total_delay_ms(agent, 4) == 100 + 200 + 250
+  end
+
+  test "retries crashes and blank output but not timeouts or other failures" do
+    assert RetryPolicy.retryable?(agent(), %{category: :crash, message: "boom"})
+    assert RetryPolicy.retryable?(agent(), %{category: :empty_output, message: "blank"})
+    refute RetryPolicy.retryable?(agent(), %{category: :timeout, message: "slow"})
+    refute RetryPolicy.retryable?(agent(), %{category: :prompt_transform, message: "bad"})
+    refute RetryPolicy.retryable?(agent(), %{message: "no category"})
+  end
+
+  test "retries only the categories the agent lists in retry_on" do
+    agent = agent(retry_on: [:timeout])
+
+    assert RetryPolicy.retryable?(agent, %{category: :timeout, message: "slow"})
+    refute RetryPolicy.retryable?(agent, %{category: :crash, message: "boom"})
+    refute RetryPolicy.retryable?(agent, %{category: :empty_output, message: "blank"})
+  end
+
+  test "treats blank output from a clean exit as a failed attempt" do
+    assert {:error, %{category: :empty_output, output: " \n"}} =
+             RetryPolicy.check_output({:ok, " \n"}, [])
+
+    assert {:ok, ""} = RetryPolicy.check_output({:ok, ""}, allow_empty_output: true)
+    assert {:ok, "answer"} = RetryPolicy.check_output({:ok, "answer"}, [])
+
+    assert {:error, %{category: :crash}} =
+             RetryPolicy.check_output({:error, %{category: :crash}}, [])
+  end
+end
@@ -254,7 +254,7 @@ defmodule Thinktank.Integration.AgentContractTest do
 
           {output, _stderr} =
             capture_stdout_and_stderr(fn ->
-              assert CLI.execute({:ok, command}) == @exit_codes.generic_error
+              assert CLI.execute({:ok, command}) == @exit_codes.degraded
             end)
 
           {:ok, payload} = Jason.decode(String.trim(output))
@@ -264,6 +264,245 @@ defmodule Thinktank.Integration.AgentContractTest do
       end)
     end
 
+    test "summary file reflects a mix of agent successes and failures" do
+      FakePi.with_fake_pi("degraded", fn _env ->
+        workspace = Workspace.unique_tmp_dir("thinktank-agent-run-summary")
+        summary_path = Path.join(workspace, "reports/summary.json")
+
+        File.cd!(workspace, fn ->
+          assert {:ok, command} =
+                   CLI.parse_args([
+                     "research",
+                     "inspect this repo",
+                     "--no-synthesis",
+                     "--agents",
+                     "systems,dx",
+                     "--summary-file",
+                     summary_path
+                   ])
+
+          capture_stdout_and_stderr(fn ->
+            assert CLI.execute({:ok, command}) == @exit_codes.degraded
+          end)
+        end)
+
+        summary = summary_path |> File.read!() |> Jason.decode!()
+
+        assert summary["status"] == "degraded"
+        assert summary["exit_class"] == "degraded"
+        assert summary["exit_code"] == @exit_codes.degraded
+
+        agents = Map.new(summary["agents"], &{&1["name"], &1})
+        assert agents["systems"]["status"] == "ok"
+        assert agents["systems"]["attempts"] == 1
+        assert agents["dx"]["status"] == "error"
+        assert agents["dx"]["error_category"] == "crash"
+        assert is_integer(agents["dx"]["duration_ms"])
+
+        assert %{"count" => 1, "min_ms" => min_ms, "max_ms" => max_ms} =
+                 summary["latency_by_model"][agents["systems"]["model"]]
+
+        assert min_ms == agents["systems"]["duration_ms"]
+        assert max_ms == min_ms
+
+        dx_latency = summary["latency_by_model"][agents["dx"]["model"]]
+        assert dx_latency["failed"] >= 1
+        assert dx_latency["timed_out"] == 0
+      end)
+    end
+
+    test "summary file reports per-model latency that covers the agent's runtime" do
+      FakePi.with_fake_pi("success", fn _env ->
+        workspace = Workspace.unique_tmp_dir("thinktank-agent-run-latency")
+        summary_path = Path.join(workspace, "summary.json")
+        System.put_env("THINKTANK_TEST_PI_DELAY_MS", "150")
+        on_exit(fn -> System.delete_env("THINKTANK_TEST_PI_DELAY_MS") end)
+
+        File.cd!(workspace, fn ->
+          assert {:ok, command} =
+                   CLI.parse_args([
+                     "research",
+                     "inspect this repo",
+                     "--no-synthesis",
+                     "--agents",
+                     "systems",
+                     "--summary-file",
+                     summary_path
+                   ])
+
+          capture_stdout_and_stderr(fn ->
+            assert CLI.execute({:ok, command}) == @exit_codes.success
+          end)
+        end)
+
+        summary = summary_path |> File.read!() |> Jason.decode!()
+        [systems] = summary["agents"]
+        latency = summary["latency_by_model"][systems["model"]]
+
+        assert latency["count"] == 1
+        assert latency["min_ms"] >= 150
+        assert latency["min_ms"] < 30_000
+        assert latency["mean_ms"] == latency["min_ms"]
+
+        assert %{"count" => 1, "min_ms" => attempt_ms} = latency["attempt_ms"]
+        assert attempt_ms >= 150
+        assert attempt_ms <= latency["min_ms"]
+      end)
+    end
+
+    test "--no-synthesis launches no synthesizer and the summary records the skip" do
+      FakePi.with_fake_pi("success", fn _env ->
+        workspace = Workspace.unique_tmp_dir("thinktank-agent-run-no-synthesis")
+        summary_path = Path.join(workspace, "summary.json")
+
+        File.cd!(workspace, fn ->
+          assert {:ok, command} =
+                   CLI.parse_args([
+                     "research",
+                     "inspect this repo",
+                     "--no-synthesis",
+                     "--agents",
+                     "systems,dx",
+                     "--summary-file",
+                     summary_path
+                   ])
+
+          capture_stdout_and_stderr(fn ->
+            assert CLI.execute({:ok, command}) == @exit_codes.success
+          end)
+        end)
+
+        summary = summary_path |> File.read!() |> Jason.decode!()
+        assert summary["synthesis_skipped"] == true
+        assert summary["agents"] |> Enum.map(& &1["name"]) |> Enum.sort() == ["dx", "systems"]
+
+        output_dir = summary["output_dir"]
+        refute File.exists?(Path.join(output_dir, "synthesis.md"))
+
+        manifest = output_dir |> Path.join("manifest.json") |> File.read!() |> Jason.decode!()
+        refute Enum.any?(manifest["artifacts"], &(&1["name"] == "synthesis"))
+        refute Enum.any?(manifest["agents"], &(&1["name"] == "research-synth"))
+      end)
+    end
+
+    test "an unwritable --summary-file warns and keeps the run's exit code" do
+      FakePi.with_fake_pi("success", fn _env ->
+        workspace = Workspace.unique_tmp_dir("thinktank-agent-run-bad-summary")
+        blocker = Path.join(workspace, "not-a-dir")
+        File.write!(blocker, "")
+        summary_path = Path.join(blocker, "summary.json")
+
+        File.cd!(workspace, fn ->
+          assert {:ok, command} =
+                   CLI.parse_args([
+                     "research",
+                     "inspect this repo",
+                     "--no-synthesis",
+                     "--agents",
+                     "systems",
+                     "--summary-file",
+                     summary_path
+                   ])
+
+          {_stdout, stderr} =
+            capture_stdout_and_stderr(fn ->
+              assert CLI.execute({:ok, command}) == @exit_codes.success
+            end)
+
+          assert stderr =~ "Warning: could not write --summary-file #{summary_path}"
+        end)
+
+        refute File.exists?(summary_path)
+      end)
+    end
+
+    test "--tag values reach agent and synthesizer trace events and the summary" do
+      FakePi.with_fake_pi("success", fn _env ->
+        workspace = Workspace.unique_tmp_dir("thinktank-agent-run-tags")
+        summary_path = Path.join(workspace, "summary.json")
+        File.mkdir_p!(Path.join(workspace, ".thinktank"))
+
+        File.write!(Path.join(workspace, ".thinktank/config.yml"), """
+        benches:
+          research/tagged:
+            kind: research
+            description: Tagged research bench
+            agents: [systems]
+            synthesizer: research-synth
+        """)
+
+        File.cd!(workspace, fn ->
+          assert {:ok, command} =
+                   CLI.parse_args([
+                     "run",
+                     "research/tagged",
+                     "--input",
+                     "inspect this repo",
+                     "--trust-repo-config",
+                     "--tag",
+                     "team=infra",
+                     "--tag",
+                     "project=thinktank",
+                     "--summary-file",
+                     summary_path
+                   ])
+
+          capture_stdout_and_stderr(fn ->
+            assert CLI.execute({:ok, command}) == @exit_codes.success
+          end)
+        end)
+
+        tags = %{"team" => "infra", "project" => "thinktank"}
+        summary = summary_path |> File.read!() |> Jason.decode!()
+        assert summary["tags"] == tags
+
+        finished =
+          summary["output_dir"]
+          |> Path.join("trace/events.jsonl")
+          |> File.read!()
+          |> String.split("\n", trim: true)
+          |> Enum.map(&Jason.decode!/1)
+          |> Enum.filter(&(&1["event"] == "agent_finished"))
+
+        assert finished |> Enum.map(& &1["agent_name"]) |> Enum.sort() == [
+                 "research-synth",
+                 "systems"
+               ]
+
+        assert Enum.all?(finished, &(&1["tags"] == tags))
+      end)
+    end
+
+    test "text runs print per-agent progress lines with --progress always" do
+      FakePi.with_fake_pi("degraded", fn _env ->
+        workspace = Workspace.unique_tmp_dir("thinktank-agent-run-progress")
+
+        File.cd!(workspace, fn ->
+          assert {:ok, command} =
+                   CLI.parse_args([
+                     "research",
+                     "inspect this repo",
+                     "--no-synthesis",
+                     "--agents",
+                     "systems,dx",
+                     "--progress",
+                     "always"
+                   ])
+
+          {_stdout, stderr} =
+            capture_stdout_and_stderr(fn ->
+              assert CLI.execute({:ok, command}) == @exit_codes.degraded
+            end)
+
+          assert stderr =~ ~r/\[\d+\.\ds\] running 2 agent\(s\)/
+          assert stderr =~ "systems: running"
+          assert stderr =~ "systems: done"
+          assert stderr =~ "dx: failed"
+          assert stderr =~ "run degraded"
+        end)
+      end)
+    end
+
     test "partial run json preserves the stdout envelope and scratchpad artifacts" do
       FakePi.with_fake_pi("degraded", fn _env ->
         workspace = Workspace.unique_tmp_dir("thinktank-agent-run-partial")
@@ -280,7 +519,7 @@ defmodule Thinktank.Integration.AgentContractTest do
 
           {stdout, stderr} =
             capture_stdout_and_stderr(fn ->
-              assert CLI.execute({:ok, command}) == @exit_codes.generic_error
+              assert CLI.execute({:ok, command}) == @exit_codes.synthesis_failed
             end)
 
           {:ok, payload} = Jason.decode(String.trim(stdout))
@@ -3,6 +3,8 @@ defmodule Thinktank.ProviderSpecTest do
 
   alias Thinktank.ProviderSpec
 
+  @adapters "anthropic, google, ollama, openai, openrouter"
+
   test "parses supported adapters without creating new atoms" do
     assert {:ok, spec} =
              ProviderSpec.from_pair("openrouter", %{
@@ -13,12 +15,28 @@ defmodule Thinktank.ProviderSpecTest do
     assert spec.adapter == :openrouter
   end
 
+  test "parses native pi provider adapters" do
+    for {adapter, expected} <- [
+          {"anthropic", :anthropic},
+          {"google", :google},
+          {"openai", :openai}
+        ] do
+      assert {:ok, %ProviderSpec{adapter: ^expected}} =
+               ProviderSpec.from_pair(adapter, %{
+                 "adapter" => adapter,
+                 "credential_env" => "TOKEN"
+               })
+    end
+  end
+
   test "rejects unsupported adapters" do
-    assert {:error, "provider adapter must be one of openrouter"} =
+    assert {:error, message} =
              ProviderSpec.from_pair("custom", %{
                "adapter" => "custom",
                "credential_env" => "TOKEN"
              })
+
+    assert message == "provider adapter must be one of " <> @adapters
   end
 
   test "accepts atom adapters and preserves defaults" do
@@ -46,4 +64,12 @@ defmodule Thinktank.ProviderSpecTest do
                "adapter" => "openrouter"
              })
   end
+
+  test "allows keyless local adapters without a credential env" do
+    assert {:ok, %ProviderSpec{adapter: :ollama, credential_env: nil}} =
+             ProviderSpec.from_pair("ollama", %{
+               "adapter" => "ollama",
+               "defaults" => %{"endpoint" => "http://localhost:11434/v1"}
+             })
+  end
 end
@@ -0,0 +1,55 @@
+defmodule Thinktank.RedactionTest do
+  use ExUnit.Case, async: false
+  import ExUnit.CaptureLog
+
+  require Logger
+
+  alias Thinktank.Redaction
+
+  setup do
+    System.put_env("THINKTANK_REDACTION_TEST_API_KEY", "logged-secret-value")
+    Redaction.refresh_secrets()
+
+    on_exit(fn ->
+      System.delete_env("THINKTANK_REDACTION_TEST_API_KEY")
+      Redaction.refresh_secrets()
+    end)
+  end
+
+  test "redact/2 masks the given secrets and known key shapes" do
+    assert Redaction.redact(
+             %{"message" => "key a-long-secret, Bearer abcdefghijkl"},
+             ["a-long-secret"]
+           ) == %{"message" => "key ***, Bearer ***"}
+  end
+
+  test "redact/2 masks struct fields nested in maps and lists" do
+    error = %Thinktank.Error{code: :run_error, message: "key a-long-secret", details: %{}}
+
+    assert %{"errors" => [%Thinktank.Error{message: "key ***", code: :run_error}]} =
+             Redaction.redact(%{"errors" => [error]}, ["a-long-secret"])
+  end
+
+  test "redact/1 reads the cached secrets until they are refreshed" do
+    System.put_env("THINKTANK_REDACTION_LATER_TOKEN", "late-secret-value")
+    on_exit(fn -> System.delete_env("THINKTANK_REDACTION_LATER_TOKEN") end)
+
+    assert Redaction.redact("late-secret-value") == "late-secret-value"
+
+    Redaction.refresh_secrets()
+    assert Redaction.redact("late-secret-value") == "***"
+  end
+
+  test "the logger filter masks credentials in log messages" do
+    log = capture_log(fn -> Logger.warning("provider said: logged-secret-value") end)
+
+    assert log =~ "provider said: ***"
+    refute log =~ "logged-secret-value"
+  end
+
+  test "the logger filter masks format-and-args messages" do
+    event = %{level: :warning, meta: %{}, msg: {~c"key ~s", ["logged-secret-value"]}}
+
+    assert %{msg: {:string, "key ***"}} = Redaction.logger_filter(event, nil)
+  end
+end
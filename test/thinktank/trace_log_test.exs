@@ -71,6 +71,27 @@ defmodule Thinktank.TraceLogTest do
     assert (File.stat!(global_log).mode &&& 0o777) == 0o600
   end
 
+  test "trace events redact configured credential values and key shapes" do
+    output_dir = Path.join(unique_tmp_dir("thinktank-trace-redact"), "run")
+    log_dir = unique_tmp_dir("thinktank-trace-redact-logs")
+
+    with_env("THINKTANK_LOG_DIR", log_dir, fn ->
+      with_env("THINKTANK_OPENROUTER_API_KEY", "plain-credential-value", fn ->
+        TraceLog.record_event(output_dir, "agent_finished", %{
+          "error" => %{
+            "message" => "echoed plain-credential-value and sk-or-v1-0123456789abcdef"
+          }
+        })
+      end)
+    end)
+
+    [event] = read_jsonl(Path.join(output_dir, "trace/events.jsonl"))
+    assert event["error"]["message"] == "echoed *** and sk-***"
+
+    [global_log] = Path.wildcard(Path.join(log_dir, "*.jsonl"))
+    refute File.read!(global_log) =~ "plain-credential-value"
+  end
+
   test "global mirror can be disabled with THINKTANK_LOG_DIR=off" do
     output_dir = Path.join(unique_tmp_dir("thinktank-trace-log-off"), "run")
     previous_log_dir = System.get_env("THINKTANK_LOG_DIR")
@@ -183,6 +204,56 @@ defmodule Thinktank.TraceLogTest do
     refute Map.has_key?(event, "run_id")
   end
 
+  test "global logs roll over at THINKTANK_LOG_MAX_BYTES and keep THINKTANK_LOG_MAX_FILES" do
+    log_dir = unique_tmp_dir("thinktank-trace-log-rotation")
+    stale_log = Path.join(log_dir, "2026-01-01.jsonl")
+    File.write!(stale_log, ~s({"event":"old"}\n))
+
+    with_env("THINKTANK_LOG_DIR", log_dir, fn ->
+      with_env("THINKTANK_LOG_MAX_BYTES", "1", fn ->
+        with_env("THINKTANK_LOG_MAX_FILES", "2", fn ->
+          for seq <- 1..4 do
+            TraceLog.record_global_event("agent_finished", %{
+              "timestamp" => "2026-01-02T00:00:00Z",
+              "seq" => seq
+            })
+          end
+        end)
+      end)
+    end)
+
+    [live, rolled] = log_dir |> Path.join("*.jsonl") |> Path.wildcard() |> Enum.sort(:desc)
+
+    assert Path.basename(live) == "2026-01-02.jsonl"
+    assert Path.basename(rolled) =~ ~r/^2026-01-02\.\d+\.jsonl$/
+    assert [%{"seq" => 4}] = read_jsonl(live)
+    assert [%{"seq" => 3}] = read_jsonl(rolled)
+    refute File.exists?(stale_log)
+  end
+
+  test "events recorded before a crash stay on disk, with or without fsync" do
+    for fsync <- ["0", "1"] do
+      output_dir = Path.join(unique_tmp_dir("thinktank-trace-log-crash"), "run")
+
+      with_env("THINKTANK_LOG_DIR", "off", fn ->
+        with_env("THINKTANK_TRACE_FSYNC", fsync, fn ->
+          {pid, ref} =
+            spawn_monitor(fn ->
+              TraceLog.init_run(output_dir)
+              TraceLog.record_event(output_dir, "agent_finished", %{"agent" => "systems"})
+              TraceLog.record_event(output_dir, "agent_finished", %{"agent" => "dx"})
+              exit(:crash)
+            end)
+
+          assert_receive {:DOWN, ^ref, :process, ^pid, :crash}
+        end)
+      end)
+
+      events = read_jsonl(Path.join(output_dir, TraceLog.events_file()))
+      assert Enum.map(events, & &1["agent"]) == ["systems", "dx"]
+    end
+  end
+
   test "record_event degrades when a live lock holder exceeds the timeout" do
     output_dir = Path.join(unique_tmp_dir("thinktank-trace-log-lock-timeout"), "run")
     TraceLog.init_run(output_dir, %{"bench" => "review/default"})